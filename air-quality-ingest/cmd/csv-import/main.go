@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"api/internal/csvimport"
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/queue"
+
+	"github.com/joho/godotenv"
+	"github.com/streadway/amqp"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	defaults := csvimport.DefaultColumnMapping()
+	file := flag.String("file", "", "path to the CSV file to import (required)")
+	latitude := flag.String("latitude", defaults.Latitude, "latitude column name")
+	longitude := flag.String("longitude", defaults.Longitude, "longitude column name")
+	parameter := flag.String("parameter", defaults.Parameter, "parameter column name")
+	value := flag.String("value", defaults.Value, "value column name")
+	timestamp := flag.String("timestamp", defaults.Timestamp, "timestamp column name")
+	unit := flag.String("unit", defaults.Unit, "unit column name (optional)")
+	timeLayout := flag.String("time-layout", defaults.TimeLayout, "Go time layout of the timestamp column")
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	conn, err := amqp.Dial(os.Getenv("RABBITMQ_URL"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if err := queue.DeclareTopology(conn); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	mapping := csvimport.ColumnMapping{
+		Latitude:   *latitude,
+		Longitude:  *longitude,
+		Parameter:  *parameter,
+		Value:      *value,
+		Timestamp:  *timestamp,
+		Unit:       *unit,
+		TimeLayout: *timeLayout,
+	}
+
+	// Rows take the same path as the HTTP import endpoints; throttling is
+	// waited out rather than stopping the import.
+	pipeline := ingest.NewPipelineFromEnv(conn)
+	publish := func(payload models.AirQualityPayload) error {
+		_, err := pipeline.IngestBulkWait(payload)
+		return err
+	}
+	importer := csvimport.NewImporter(mapping, publish)
+	summary, importErr := importer.Import(f)
+
+	out, _ := json.MarshalIndent(summary, "", "  ")
+	fmt.Println(string(out))
+
+	if importErr != nil {
+		fmt.Println(importErr)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/openaq"
+	"api/internal/queue"
+
+	"github.com/joho/godotenv"
+	"github.com/streadway/amqp"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	format := flag.String("format", "", "input format: json or csv (default: from file extension)")
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		fmt.Println("Usage: openaq-import [-format json|csv] <archive files (.json, .ndjson, .csv, optionally .gz)...>")
+		os.Exit(2)
+	}
+
+	conn, err := amqp.Dial(os.Getenv("RABBITMQ_URL"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if err := queue.DeclareTopology(conn); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Rows take the same path as the HTTP import endpoints; throttling is
+	// waited out rather than stopping the import.
+	pipeline := ingest.NewPipelineFromEnv(conn)
+	publish := func(payload models.AirQualityPayload) error {
+		_, err := pipeline.IngestBulkWait(payload)
+		return err
+	}
+	importer := openaq.NewImporter(publish)
+
+	failed := false
+	for _, file := range files {
+		summary, err := importFile(importer, file, *format)
+
+		out, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Printf("%s: %s\n", file, out)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			break
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func importFile(importer *openaq.Importer, path, format string) (openaq.Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return openaq.Summary{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	name := path
+	if strings.EqualFold(filepath.Ext(name), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return openaq.Summary{}, err
+		}
+		defer gz.Close()
+		r = gz
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	if format == "" {
+		format = openaq.FormatJSON
+		if strings.EqualFold(filepath.Ext(name), ".csv") {
+			format = openaq.FormatCSV
+		}
+	}
+
+	return importer.Import(r, format)
+}
@@ -7,6 +7,13 @@ import (
 	"time"
 
 	"api/internal/api"
+	grpcserver "api/internal/grpc"
+	"api/internal/ingest"
+	mqttlistener "api/internal/mqtt"
+	"api/internal/queue"
+	"api/internal/repository"
+	"api/internal/signing"
+	"api/pkg/db"
 
 	"github.com/joho/godotenv"
 	"github.com/streadway/amqp"
@@ -19,10 +26,12 @@ type app struct {
 func main() {
 	_ = godotenv.Load()
 	rabbitMQURL := os.Getenv("RABBITMQ_URL")
+	dbURL := os.Getenv("DATABASE_URL")
 
 	time.Sleep(10 * time.Second)
 
 	port := ":8000"
+	grpcPort := ":9000"
 
 	conn, err := amqp.Dial(rabbitMQURL)
 	if err != nil {
@@ -36,7 +45,47 @@ func main() {
 
 	defer conn.Close()
 
-	router := api.NewRouter(app.QueueConn)
+	if err := queue.DeclareTopology(conn); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	pipeline := ingest.NewPipelineFromEnv(app.QueueConn)
+
+	// Signatures are checked against the device registry in the database.
+	// Without DATABASE_URL every reading is accepted unsigned.
+	var verifier *signing.Verifier
+	var devices *repository.DeviceRepository
+	signingConfig := signing.ConfigFromEnv()
+	if dbURL != "" {
+		database := db.InitDB(dbURL)
+		defer database.Close()
+		devices = repository.NewDeviceRepository(database)
+		verifier = signing.NewVerifier(devices, signingConfig)
+	} else if signingConfig.Required {
+		fmt.Println("SIGNATURE_REQUIRED is set but DATABASE_URL is empty")
+		os.Exit(1)
+	}
+
+	if mqttConfig, ok := mqttlistener.ConfigFromEnv(); ok {
+		listener := mqttlistener.NewListener(pipeline, verifier, devices, mqttConfig)
+		if err := listener.Start(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer listener.Stop()
+		fmt.Println("MQTT listener connected to", mqttConfig.BrokerURL)
+	}
+
+	grpcServer := grpcserver.NewServer(pipeline, verifier)
+	go func() {
+		if err := grpcServer.StartGrpcServer(grpcPort); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}()
+
+	router := api.NewRouter(app.QueueConn, pipeline, verifier)
 
 	r := router.NewRouter()
 
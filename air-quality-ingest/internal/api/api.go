@@ -1,45 +1,449 @@
 package api
 
 import (
+	"api/internal/codec"
+	"api/internal/csvimport"
+	"api/internal/ingest"
+	"api/internal/lineprotocol"
 	"api/internal/models"
-	"api/internal/queue"
+	"api/internal/openaq"
+	"api/internal/signing"
+	"api/internal/validation"
 	"api/pkg/utils"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/streadway/amqp"
 )
 
+const maxUploadSize = 64 << 20
+
 type Router struct {
 	QueueConn *amqp.Connection
+	Pipeline  *ingest.Pipeline
+	Verifier  *signing.Verifier
 }
 
-func NewRouter(QueueConn *amqp.Connection) *Router {
+func NewRouter(QueueConn *amqp.Connection, Pipeline *ingest.Pipeline, Verifier *signing.Verifier) *Router {
 	return &Router{
 		QueueConn: QueueConn,
+		Pipeline:  Pipeline,
+		Verifier:  Verifier,
 	}
 }
 
 func (r *Router) NewRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.HandleFunc("/api/ingest", r.IngestHandler).Methods(http.MethodPost, http.MethodOptions)
+	router.HandleFunc("/api/ingest/csv", r.CSVIngestHandler).Methods(http.MethodPost, http.MethodOptions)
+	router.HandleFunc("/api/ingest/openaq", r.OpenAQIngestHandler).Methods(http.MethodPost, http.MethodOptions)
+	router.HandleFunc("/api/write", r.LineProtocolWriteHandler).Methods(http.MethodPost, http.MethodOptions)
+	router.HandleFunc("/api/v2/write", r.LineProtocolWriteHandler).Methods(http.MethodPost, http.MethodOptions)
 
 	return router
 }
 
+// IngestHandler accepts a single reading encoded as JSON, Protocol Buffers
+// (ingestpb.Reading) or CBOR, optionally gzip compressed. The idempotency
+// key is taken from the Idempotency-Key header, the payload's messageId or,
+// failing both, derived from the reading; a repeated key is answered with
+// 409 Conflict instead of being queued twice.
+//
+// Signed readings carry the X-Sensor-Id, X-Signature-Timestamp,
+// X-Signature-Nonce and X-Signature headers; the signature covers the raw
+// body as sent (before gzip decoding) and is checked before the body is
+// decoded. Failed checks are answered with 401 Unauthorized.
 func (r *Router) IngestHandler(w http.ResponseWriter, req *http.Request) {
-	var payload models.AirQualityPayload
-	err := utils.DecodeRequestBody(req, &payload)
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+	raw, err := io.ReadAll(req.Body)
 	if err != nil {
 		utils.JSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	queue := queue.NewQueue(r.QueueConn)
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+
+	message := signing.MessageFromRequest(req, raw)
+	if message.Signed() {
+		if err := r.Verifier.Verify(message); err != nil {
+			signatureError(w, err)
+			return
+		}
+	}
+
+	payload, err := codec.DecodePayload(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, codec.ErrUnsupportedMediaType) {
+			status = http.StatusUnsupportedMediaType
+		}
+		utils.JSONError(w, status, err.Error())
+		return
+	}
 
-	if err := queue.PublishToQueue(payload); err != nil {
+	if message.Signed() {
+		if err := r.Verifier.CheckSensor(message, payload.SensorID); err != nil {
+			signatureError(w, err)
+			return
+		}
+		payload.SensorID = message.SensorID
+	} else {
+		if payload.SensorID != "" {
+			message.SensorID = payload.SensorID
+		}
+		if err := r.Verifier.Verify(message); err != nil {
+			signatureError(w, err)
+			return
+		}
+	}
+
+	if key := req.Header.Get("Idempotency-Key"); key != "" {
+		payload.MessageID = key
+	}
+
+	payload, err = r.Pipeline.Ingest(payload)
+	if err != nil {
+		if errors.Is(err, validation.ErrInvalidPayload) {
+			utils.JSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, ingest.ErrDuplicate) {
+			utils.JSONResponse(w, http.StatusConflict, ingestResponse{MessageID: payload.MessageID, Error: err.Error()})
+			return
+		}
+		if status, ok := throttleStatus(w, err); ok {
+			utils.JSONError(w, status, err.Error())
+			return
+		}
 		http.Error(w, "Failed to publish message", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	utils.JSONResponse(w, http.StatusOK, ingestResponse{MessageID: payload.MessageID})
+}
+
+type ingestResponse struct {
+	MessageID string `json:"messageId"`
+	Error     string `json:"error,omitempty"`
+}
+
+type csvIngestResponse struct {
+	csvimport.Summary
+	Error string `json:"error,omitempty"`
+}
+
+// CSVIngestHandler accepts a CSV export either as the raw request body or as
+// the "file" field of a multipart form. Column names default to the payload
+// field names and can be overridden with query parameters, e.g.
+// ?latitude=Lat&longitude=Lon&timestamp=Date&timeLayout=2006-01-02 15:04.
+// Rows go through the pipeline's bulk path, which skips the per-device rate
+// limit: readings already ingested are counted as duplicates, and when the
+// global rate limit or the backlog stops the import the rows queued so far
+// are reported with 429 or 503. Uploads are unsigned, so they are refused
+// with 401 when every reading must be signed.
+func (r *Router) CSVIngestHandler(w http.ResponseWriter, req *http.Request) {
+	if r.refuseUnsignedImport(w, req, "csv") {
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+
+	body, closeBody, err := csvUploadBody(req)
+	if err != nil {
+		utils.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer closeBody()
+
+	if err := r.Pipeline.CheckBacklog(); err != nil {
+		status, _ := throttleStatus(w, err)
+		utils.JSONError(w, status, err.Error())
+		return
+	}
+
+	importer := csvimport.NewImporter(columnMappingFromQuery(req), r.importer(req, "csv"))
+
+	summary, err := importer.Import(body)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, csvimport.ErrInvalidHeader) {
+			status = http.StatusBadRequest
+		} else if throttled, ok := throttleStatus(w, err); ok {
+			status = throttled
+		}
+		utils.JSONResponse(w, status, csvIngestResponse{Summary: summary, Error: err.Error()})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, csvIngestResponse{Summary: summary})
+}
+
+type openAQIngestResponse struct {
+	openaq.Summary
+	Error string `json:"error,omitempty"`
+}
+
+// OpenAQIngestHandler imports OpenAQ measurements (JSON or CSV, picked with
+// ?format= or from the Content-Type) through the pipeline's bulk path. Readings
+// already ingested, by an earlier import or any other transport, are
+// skipped and counted as duplicates. Like CSV uploads, imports are refused
+// with 401 when every reading must be signed.
+func (r *Router) OpenAQIngestHandler(w http.ResponseWriter, req *http.Request) {
+	if r.refuseUnsignedImport(w, req, "openaq") {
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+
+	body, closeBody, err := csvUploadBody(req)
+	if err != nil {
+		utils.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer closeBody()
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = openaq.FormatJSON
+		if strings.Contains(req.Header.Get("Content-Type"), "csv") {
+			format = openaq.FormatCSV
+		}
+	}
+
+	if err := r.Pipeline.CheckBacklog(); err != nil {
+		status, _ := throttleStatus(w, err)
+		utils.JSONError(w, status, err.Error())
+		return
+	}
+
+	importer := openaq.NewImporter(r.importer(req, "openaq"))
+
+	summary, err := importer.Import(body, format)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, openaq.ErrInvalidFormat) {
+			status = http.StatusBadRequest
+		} else if throttled, ok := throttleStatus(w, err); ok {
+			status = throttled
+		}
+		utils.JSONResponse(w, status, openAQIngestResponse{Summary: summary, Error: err.Error()})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, openAQIngestResponse{Summary: summary})
+}
+
+// lineProtocolResponse counts lines, not readings: a line with several
+// pollutant fields is accepted, rejected or a duplicate as a whole.
+type lineProtocolResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	csvimport.Summary
+}
+
+// LineProtocolWriteHandler accepts InfluxDB line protocol so Telegraf agents
+// and data loggers can push readings unchanged. It answers like InfluxDB:
+// 204 when every line was written, 400 when some lines were rejected and
+// 503 when the queue is unavailable so the client retries. Like InfluxDB's
+// partial writes, a 400 still queues the valid lines; a line is checked as
+// a whole before any of its readings is queued, so it is never half
+// written. Lines whose readings were all ingested before are counted as
+// duplicates and do not fail the write.
+func (r *Router) LineProtocolWriteHandler(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+
+	precision := req.URL.Query().Get("precision")
+	if !lineprotocol.ValidPrecision(precision) {
+		utils.JSONResponse(w, http.StatusBadRequest, lineProtocolResponse{Code: "invalid", Message: "invalid precision " + precision})
+		return
+	}
+
+	body, err := codec.RequestBody(req)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusBadRequest, lineProtocolResponse{Code: "invalid", Message: err.Error()})
+		return
+	}
+	defer body.Close()
+
+	summary := csvimport.Summary{Errors: []csvimport.RowError{}}
+	now := time.Now().UTC()
+	charged := map[string]bool{}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxUploadSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		point, err := lineprotocol.ParseLine(text, precision, now)
+		if err != nil {
+			summary.Reject(line, err.Error())
+			continue
+		}
+		payloads, err := lineprotocol.Payloads(point)
+		if err == nil {
+			err = validateLine(payloads)
+		}
+		if err != nil {
+			summary.Reject(line, err.Error())
+			continue
+		}
+
+		// Line protocol cannot carry a signature, so readings from sensors
+		// that must sign are refused here. All fields of a line share the
+		// sensor tag.
+		err = r.Verifier.Verify(signing.Message{SensorID: payloads[0].SensorID, Transport: "line-protocol", RemoteAddr: req.RemoteAddr})
+		if errors.Is(err, signing.ErrSignature) {
+			summary.Reject(line, err.Error())
+			continue
+		}
+		if err != nil {
+			utils.JSONResponse(w, http.StatusServiceUnavailable, lineProtocolResponse{Code: "unavailable", Message: "failed to verify signature", Summary: summary})
+			return
+		}
+
+		queued := false
+		for _, payload := range payloads {
+			// A device's bucket is charged once per request, not per
+			// reading, so an agent flushing a batch is not throttled by it.
+			ingestReading := r.Pipeline.IngestBulk
+			if device := ingest.DeviceID(payload); !charged[device] {
+				ingestReading = r.Pipeline.Ingest
+				charged[device] = true
+			}
+			_, err := ingestReading(payload)
+			if errors.Is(err, ingest.ErrDuplicate) {
+				continue
+			}
+			if err != nil {
+				if status, ok := throttleStatus(w, err); ok {
+					utils.JSONResponse(w, status, lineProtocolResponse{Code: "too many requests", Message: err.Error(), Summary: summary})
+					return
+				}
+				utils.JSONResponse(w, http.StatusServiceUnavailable, lineProtocolResponse{Code: "unavailable", Message: "failed to publish message", Summary: summary})
+				return
+			}
+			queued = true
+		}
+		if queued {
+			summary.Accepted++
+		} else {
+			summary.Duplicates++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		utils.JSONResponse(w, http.StatusBadRequest, lineProtocolResponse{Code: "invalid", Message: err.Error(), Summary: summary})
+		return
+	}
+
+	if summary.Rejected > 0 {
+		message := fmt.Sprintf("line %d: %s", summary.Errors[0].Line, summary.Errors[0].Error)
+		utils.JSONResponse(w, http.StatusBadRequest, lineProtocolResponse{Code: "invalid", Message: message, Summary: summary})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateLine checks every reading of a line, so that a line with one
+// invalid field is rejected before any of its readings is queued.
+func validateLine(payloads []models.AirQualityPayload) error {
+	for _, payload := range payloads {
+		if err := validation.NormalizePayload(&payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refuseUnsignedImport answers 401 when every reading must be signed:
+// uploads carry no signatures. It reports whether it answered.
+func (r *Router) refuseUnsignedImport(w http.ResponseWriter, req *http.Request, transport string) bool {
+	err := r.Verifier.Verify(signing.Message{Transport: transport, RemoteAddr: req.RemoteAddr})
+	if err != nil {
+		signatureError(w, err)
+		return true
+	}
+	return false
+}
+
+// importer sends imported rows through the pipeline's bulk path. Rows are
+// unsigned, so a row with the sensor ID of a device that must sign is
+// rejected like an unsigned reading on the other transports.
+func (r *Router) importer(req *http.Request, transport string) csvimport.PublishFunc {
+	return func(payload models.AirQualityPayload) error {
+		message := signing.Message{SensorID: payload.SensorID, Transport: transport, RemoteAddr: req.RemoteAddr}
+		if err := r.Verifier.Verify(message); err != nil {
+			return err
+		}
+		_, err := r.Pipeline.IngestBulk(payload)
+		return err
+	}
+}
+
+// signatureError answers 401 for a rejected signature and 503 when the
+// device registry could not be read.
+func signatureError(w http.ResponseWriter, err error) {
+	if errors.Is(err, signing.ErrSignature) {
+		utils.JSONError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	utils.JSONError(w, http.StatusServiceUnavailable, "failed to verify signature")
+}
+
+// throttleStatus maps an ingest.ThrottleError to 429 (rate limited) or 503
+// (queue backlog) and sets the Retry-After header.
+func throttleStatus(w http.ResponseWriter, err error) (int, bool) {
+	var throttle *ingest.ThrottleError
+	if !errors.As(err, &throttle) {
+		return 0, false
+	}
+
+	seconds := int(math.Ceil(throttle.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+
+	if errors.Is(err, ingest.ErrRateLimited) {
+		return http.StatusTooManyRequests, true
+	}
+	return http.StatusServiceUnavailable, true
+}
+
+func csvUploadBody(req *http.Request) (io.Reader, func(), error) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		return req.Body, func() {}, nil
+	}
+
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+func columnMappingFromQuery(req *http.Request) csvimport.ColumnMapping {
+	mapping := csvimport.DefaultColumnMapping()
+	query := req.URL.Query()
+
+	override := func(target *string, key string) {
+		if value := query.Get(key); value != "" {
+			*target = value
+		}
+	}
+	override(&mapping.Latitude, "latitude")
+	override(&mapping.Longitude, "longitude")
+	override(&mapping.Parameter, "parameter")
+	override(&mapping.Value, "value")
+	override(&mapping.Timestamp, "timestamp")
+	override(&mapping.Unit, "unit")
+	override(&mapping.TimeLayout, "timeLayout")
+
+	return mapping
 }
@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"api/internal/models"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// compactReading is the integer-keyed CBOR map sent by constrained sensors.
+// The timestamp is epoch seconds, either tagged (tag 1) or a bare number.
+type compactReading struct {
+	MessageID string    `cbor:"7,keyasint,omitempty"`
+	SensorID  string    `cbor:"0,keyasint,omitempty"`
+	Latitude  float64   `cbor:"1,keyasint"`
+	Longitude float64   `cbor:"2,keyasint"`
+	Parameter string    `cbor:"3,keyasint"`
+	Value     float64   `cbor:"4,keyasint"`
+	Unit      string    `cbor:"5,keyasint,omitempty"`
+	Timestamp time.Time `cbor:"6,keyasint,omitempty"`
+}
+
+// textReading accepts CBOR maps keyed with the JSON field names.
+type textReading struct {
+	MessageID string    `cbor:"messageId"`
+	SensorID  string    `cbor:"sensorId"`
+	Latitude  float64   `cbor:"latitude"`
+	Longitude float64   `cbor:"longitude"`
+	Parameter string    `cbor:"parameter"`
+	Value     float64   `cbor:"value"`
+	Unit      string    `cbor:"unit"`
+	Timestamp time.Time `cbor:"timestamp"`
+}
+
+func decodeCBOR(data []byte) (models.AirQualityPayload, error) {
+	var keys map[interface{}]cbor.RawMessage
+	if err := cbor.Unmarshal(data, &keys); err != nil {
+		return models.AirQualityPayload{}, err
+	}
+
+	textKeys := false
+	for key := range keys {
+		_, textKeys = key.(string)
+		break
+	}
+
+	if textKeys {
+		var reading textReading
+		if err := cbor.Unmarshal(data, &reading); err != nil {
+			return models.AirQualityPayload{}, err
+		}
+		return models.AirQualityPayload(reading), nil
+	}
+
+	var reading compactReading
+	if err := cbor.Unmarshal(data, &reading); err != nil {
+		return models.AirQualityPayload{}, err
+	}
+	return models.AirQualityPayload(reading), nil
+}
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"api/internal/grpc/ingestpb"
+	"api/internal/models"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeCBOR     = "application/cbor"
+
+	maxBodySize = 1 << 20
+)
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrMalformedBody        = errors.New("malformed request body")
+)
+
+// DecodePayload decodes a single reading according to the request's
+// Content-Type (JSON when absent) after undoing a gzip Content-Encoding.
+func DecodePayload(req *http.Request) (models.AirQualityPayload, error) {
+	var payload models.AirQualityPayload
+
+	body, err := RequestBody(req)
+	if err != nil {
+		return payload, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return payload, fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	if len(data) > maxBodySize {
+		return payload, fmt.Errorf("%w: body exceeds %d bytes", ErrMalformedBody, maxBodySize)
+	}
+
+	switch mediaType(req) {
+	case ContentTypeJSON:
+		err = json.Unmarshal(data, &payload)
+	case ContentTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+		var reading ingestpb.Reading
+		if err = proto.Unmarshal(data, &reading); err == nil {
+			payload = PayloadFromReading(&reading)
+		}
+	case ContentTypeCBOR:
+		payload, err = decodeCBOR(data)
+	default:
+		return payload, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, req.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		return payload, fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+
+	return payload, nil
+}
+
+func mediaType(req *http.Request) string {
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return ContentTypeJSON
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mt
+}
+
+// RequestBody returns the request body with any gzip Content-Encoding undone.
+func RequestBody(req *http.Request) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return req.Body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedBody, err)
+		}
+		return gz, nil
+	default:
+		return nil, fmt.Errorf("%w: content encoding %s", ErrUnsupportedMediaType, req.Header.Get("Content-Encoding"))
+	}
+}
+
+func PayloadFromReading(reading *ingestpb.Reading) models.AirQualityPayload {
+	payload := models.AirQualityPayload{
+		MessageID: reading.GetMessageId(),
+		SensorID:  reading.GetSensorId(),
+		Latitude:  reading.GetLatitude(),
+		Longitude: reading.GetLongitude(),
+		Parameter: reading.GetParameter(),
+		Value:     reading.GetValue(),
+		Unit:      reading.GetUnit(),
+	}
+	if reading.GetTimestamp() != nil {
+		payload.Timestamp = reading.GetTimestamp().AsTime()
+	}
+	return payload
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"api/internal/models"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func mustCBOR(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to encode CBOR: %v", err)
+	}
+	return data
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	want := models.AirQualityPayload{
+		SensorID:  "ST-001",
+		Latitude:  41.01,
+		Longitude: 28.97,
+		Parameter: "PM2.5",
+		Value:     12.5,
+		Unit:      "µg/m³",
+		Timestamp: timestamp,
+	}
+
+	tests := []struct {
+		name string
+		body interface{}
+		want models.AirQualityPayload
+	}{
+		{
+			name: "integer keys with tagged epoch timestamp",
+			body: map[int]interface{}{
+				0: "ST-001", 1: 41.01, 2: 28.97, 3: "PM2.5", 4: 12.5, 5: "µg/m³",
+				6: cbor.Tag{Number: 1, Content: timestamp.Unix()},
+			},
+			want: want,
+		},
+		{
+			name: "integer keys with bare epoch timestamp",
+			body: map[int]interface{}{
+				0: "ST-001", 1: 41.01, 2: 28.97, 3: "PM2.5", 4: 12.5, 5: "µg/m³", 6: timestamp.Unix(),
+			},
+			want: want,
+		},
+		{
+			name: "integer keys without optional fields",
+			body: map[int]interface{}{1: 41.01, 2: 28.97, 3: "NO2", 4: uint64(40)},
+			want: models.AirQualityPayload{Latitude: 41.01, Longitude: 28.97, Parameter: "NO2", Value: 40},
+		},
+		{
+			name: "text keys with RFC 3339 timestamp",
+			body: map[string]interface{}{
+				"messageId": "m-1", "sensorId": "ST-001", "latitude": 41.01, "longitude": 28.97,
+				"parameter": "PM2.5", "value": 12.5, "unit": "µg/m³", "timestamp": "2024-01-15T10:30:00Z",
+			},
+			want: func() models.AirQualityPayload {
+				p := want
+				p.MessageID = "m-1"
+				return p
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCBOR(mustCBOR(t, tt.body))
+			if err != nil {
+				t.Fatalf("decodeCBOR returned error: %v", err)
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("decodeCBOR = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	reading := mustCBOR(t, map[int]interface{}{1: 41.01, 2: 28.97, 3: "O3", 4: 80.0})
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(reading)
+	gz.Close()
+
+	tests := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		body            []byte
+		wantErr         error
+	}{
+		{name: "cbor", contentType: "application/cbor", body: reading},
+		{name: "gzip compressed cbor", contentType: "application/cbor", contentEncoding: "gzip", body: gzipped.Bytes()},
+		{name: "json by default", body: []byte(`{"latitude":41.01,"longitude":28.97,"parameter":"O3","value":80}`)},
+		{name: "truncated cbor", contentType: "application/cbor", body: reading[:len(reading)-3], wantErr: ErrMalformedBody},
+		{name: "cbor array instead of map", contentType: "application/cbor", body: mustCBOR(t, []float64{41.01, 28.97}), wantErr: ErrMalformedBody},
+		{name: "gzip header missing", contentType: "application/cbor", contentEncoding: "gzip", body: reading, wantErr: ErrMalformedBody},
+		{name: "unknown content type", contentType: "text/xml", body: reading, wantErr: ErrUnsupportedMediaType},
+		{name: "unknown content encoding", contentType: "application/cbor", contentEncoding: "br", body: reading, wantErr: ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/ingest", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+
+			payload, err := DecodePayload(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DecodePayload error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodePayload returned error: %v", err)
+			}
+			if payload.Parameter != "O3" || payload.Value != 80 || payload.Latitude != 41.01 || payload.Longitude != 28.97 {
+				t.Errorf("DecodePayload = %+v", payload)
+			}
+		})
+	}
+}
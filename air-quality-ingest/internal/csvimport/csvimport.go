@@ -0,0 +1,234 @@
+package csvimport
+
+import (
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/signing"
+	"api/internal/validation"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnMapping names the CSV header columns holding each payload field.
+// Unit is optional; when empty every row is assumed to be in µg/m³.
+type ColumnMapping struct {
+	Latitude   string `json:"latitude"`
+	Longitude  string `json:"longitude"`
+	Parameter  string `json:"parameter"`
+	Value      string `json:"value"`
+	Timestamp  string `json:"timestamp"`
+	Unit       string `json:"unit"`
+	TimeLayout string `json:"timeLayout"`
+}
+
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		Latitude:   "latitude",
+		Longitude:  "longitude",
+		Parameter:  "parameter",
+		Value:      "value",
+		Timestamp:  "timestamp",
+		Unit:       "unit",
+		TimeLayout: time.RFC3339,
+	}
+}
+
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Summary reports the outcome of an import. Duplicates counts readings
+// that were already ingested and were therefore not queued again.
+type Summary struct {
+	Accepted   int        `json:"accepted"`
+	Rejected   int        `json:"rejected"`
+	Duplicates int        `json:"duplicates"`
+	Errors     []RowError `json:"errors"`
+}
+
+var ErrInvalidHeader = errors.New("invalid csv header")
+
+// PublishFunc queues one reading. It may return ingest.ErrDuplicate for a
+// reading that was already ingested, which is counted rather than treated
+// as a failure, and rejects the row with a validation.ErrInvalidPayload or
+// signing.ErrSignature error.
+type PublishFunc func(models.AirQualityPayload) error
+
+type Importer struct {
+	Mapping ColumnMapping
+	Publish PublishFunc
+}
+
+func NewImporter(mapping ColumnMapping, publish PublishFunc) *Importer {
+	return &Importer{
+		Mapping: mapping,
+		Publish: publish,
+	}
+}
+
+// Import streams the CSV rows one at a time through validation and Publish.
+// Row-level problems and duplicates are collected in the summary; an error
+// is only returned when the header is unusable or publishing fails (or is
+// throttled), since the remaining rows could not be delivered either.
+func (i *Importer) Import(r io.Reader) (Summary, error) {
+	summary := Summary{Errors: []RowError{}}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return summary, fmt.Errorf("%w: csv file is empty", ErrInvalidHeader)
+		}
+		return summary, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	columns, err := i.resolveColumns(header)
+	if err != nil {
+		return summary, err
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				summary.Reject(parseErr.StartLine, parseErr.Err.Error())
+				continue
+			}
+			return summary, fmt.Errorf("failed to read csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		payload, err := i.parseRecord(record, columns)
+		if err == nil {
+			err = validation.NormalizePayload(&payload)
+		}
+		if err != nil {
+			summary.Reject(line, err.Error())
+			continue
+		}
+
+		err = i.Publish(payload)
+		switch {
+		case errors.Is(err, ingest.ErrDuplicate):
+			summary.Duplicates++
+		case errors.Is(err, validation.ErrInvalidPayload), errors.Is(err, signing.ErrSignature):
+			summary.Reject(line, err.Error())
+		case err != nil:
+			return summary, fmt.Errorf("failed to publish line %d: %w", line, err)
+		default:
+			summary.Accepted++
+		}
+	}
+
+	return summary, nil
+}
+
+func (s *Summary) Reject(line int, message string) {
+	s.Rejected++
+	s.Errors = append(s.Errors, RowError{Line: line, Error: message})
+}
+
+type columnIndexes struct {
+	latitude, longitude, parameter, value, timestamp, unit int
+}
+
+func (i *Importer) resolveColumns(header []string) (columnIndexes, error) {
+	positions := make(map[string]int, len(header))
+	for idx, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		positions[name] = idx
+	}
+
+	lookup := func(column string, required bool) (int, error) {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" && !required {
+			return -1, nil
+		}
+		idx, ok := positions[column]
+		if !ok {
+			if required {
+				return -1, fmt.Errorf("%w: missing column %q", ErrInvalidHeader, column)
+			}
+			return -1, nil
+		}
+		return idx, nil
+	}
+
+	var columns columnIndexes
+	var err error
+	if columns.latitude, err = lookup(i.Mapping.Latitude, true); err != nil {
+		return columns, err
+	}
+	if columns.longitude, err = lookup(i.Mapping.Longitude, true); err != nil {
+		return columns, err
+	}
+	if columns.parameter, err = lookup(i.Mapping.Parameter, true); err != nil {
+		return columns, err
+	}
+	if columns.value, err = lookup(i.Mapping.Value, true); err != nil {
+		return columns, err
+	}
+	if columns.timestamp, err = lookup(i.Mapping.Timestamp, true); err != nil {
+		return columns, err
+	}
+	if columns.unit, err = lookup(i.Mapping.Unit, false); err != nil {
+		return columns, err
+	}
+
+	return columns, nil
+}
+
+func (i *Importer) parseRecord(record []string, columns columnIndexes) (models.AirQualityPayload, error) {
+	var payload models.AirQualityPayload
+
+	field := func(idx int) string {
+		if idx < 0 || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	lat, err := strconv.ParseFloat(field(columns.latitude), 64)
+	if err != nil {
+		return payload, fmt.Errorf("invalid latitude %q", field(columns.latitude))
+	}
+	lon, err := strconv.ParseFloat(field(columns.longitude), 64)
+	if err != nil {
+		return payload, fmt.Errorf("invalid longitude %q", field(columns.longitude))
+	}
+	value, err := strconv.ParseFloat(field(columns.value), 64)
+	if err != nil {
+		return payload, fmt.Errorf("invalid value %q", field(columns.value))
+	}
+
+	layout := i.Mapping.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	timestamp, err := time.Parse(layout, field(columns.timestamp))
+	if err != nil {
+		return payload, fmt.Errorf("invalid timestamp %q (expected layout %s)", field(columns.timestamp), layout)
+	}
+
+	payload.Latitude = lat
+	payload.Longitude = lon
+	payload.Parameter = field(columns.parameter)
+	payload.Value = value
+	payload.Unit = field(columns.unit)
+	payload.Timestamp = timestamp
+
+	return payload, nil
+}
@@ -0,0 +1,152 @@
+package csvimport
+
+import (
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/validation"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportColumnMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping ColumnMapping
+		csv     string
+		want    []models.AirQualityPayload
+	}{
+		{
+			name:    "default columns in any order and case",
+			mapping: DefaultColumnMapping(),
+			csv: "\ufeffTimestamp, Value ,PARAMETER,latitude,longitude\n" +
+				"2024-01-15T10:30:00Z,12.5,pm25,41.01,28.97\n",
+			want: []models.AirQualityPayload{
+				{Latitude: 41.01, Longitude: 28.97, Parameter: "PM2.5", Value: 12.5, Unit: validation.DefaultUnit, Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name: "renamed columns with a custom time layout",
+			mapping: ColumnMapping{
+				Latitude: "Lat", Longitude: "Lon", Parameter: "Pollutant", Value: "Reading",
+				Timestamp: "Date", Unit: "Units", TimeLayout: "2006-01-02 15:04",
+			},
+			csv: "Station,Date,Pollutant,Reading,Units,Lat,Lon\n" +
+				"Kadıköy,2024-01-15 10:30,PM10,30,µg/m³,40.99,29.03\n" +
+				"Kadıköy,2024-01-15 11:30,O3,80,,40.99,29.03\n",
+			want: []models.AirQualityPayload{
+				{Latitude: 40.99, Longitude: 29.03, Parameter: "PM10", Value: 30, Unit: validation.DefaultUnit, Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)},
+				{Latitude: 40.99, Longitude: 29.03, Parameter: "O3", Value: 80, Unit: validation.DefaultUnit, Timestamp: time.Date(2024, 1, 15, 11, 30, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:    "mapped unit column missing from the header is optional",
+			mapping: DefaultColumnMapping(),
+			csv:     "latitude,longitude,parameter,value,timestamp\n41,29,SO2,5,2024-01-15T10:30:00+03:00\n",
+			want: []models.AirQualityPayload{
+				{Latitude: 41, Longitude: 29, Parameter: "SO2", Value: 5, Unit: validation.DefaultUnit, Timestamp: time.Date(2024, 1, 15, 7, 30, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []models.AirQualityPayload
+			importer := NewImporter(tt.mapping, func(payload models.AirQualityPayload) error {
+				payload.MessageID = ""
+				got = append(got, payload)
+				return nil
+			})
+
+			summary, err := importer.Import(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatalf("Import returned error: %v", err)
+			}
+			if summary.Accepted != len(tt.want) || summary.Rejected != 0 {
+				t.Errorf("summary = %+v, want %d accepted", summary, len(tt.want))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("published %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportInvalidHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping ColumnMapping
+		csv     string
+	}{
+		{"empty file", DefaultColumnMapping(), ""},
+		{"missing value column", DefaultColumnMapping(), "latitude,longitude,parameter,timestamp\n"},
+		{"mapping names an absent column", ColumnMapping{Latitude: "Lat", Longitude: "longitude", Parameter: "parameter", Value: "value", Timestamp: "timestamp"}, "latitude,longitude,parameter,value,timestamp\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			importer := NewImporter(tt.mapping, func(models.AirQualityPayload) error { return nil })
+			if _, err := importer.Import(strings.NewReader(tt.csv)); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("Import error = %v, want ErrInvalidHeader", err)
+			}
+		})
+	}
+}
+
+func TestImportRowOutcomes(t *testing.T) {
+	csv := "latitude,longitude,parameter,value,timestamp\n" +
+		"41,29,PM10,30,2024-01-15T10:30:00Z\n" +
+		"north,29,PM10,30,2024-01-15T10:30:00Z\n" +
+		"41,29,PM10,30,15/01/2024\n" +
+		"41,29,CO2,400,2024-01-15T10:30:00Z\n" +
+		"41,29,PM10,31,2024-01-15T11:30:00Z\n" +
+		"41,29,PM10,32,2024-01-15T12:30:00Z\n"
+
+	publish := func(payload models.AirQualityPayload) error {
+		switch payload.Value {
+		case 31:
+			return ingest.ErrDuplicate
+		case 32:
+			return fmt.Errorf("%w: rejected downstream", validation.ErrInvalidPayload)
+		}
+		return nil
+	}
+
+	summary, err := NewImporter(DefaultColumnMapping(), publish).Import(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Import returned error: %v", err)
+	}
+	if summary.Accepted != 1 || summary.Duplicates != 1 || summary.Rejected != 4 {
+		t.Errorf("summary = %+v, want 1 accepted, 1 duplicate, 4 rejected", summary)
+	}
+	var lines []int
+	for _, rowErr := range summary.Errors {
+		lines = append(lines, rowErr.Line)
+	}
+	if want := []int{3, 4, 5, 7}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("rejected lines = %v, want %v", lines, want)
+	}
+}
+
+func TestImportStopsWhenPublishingFails(t *testing.T) {
+	csv := "latitude,longitude,parameter,value,timestamp\n" +
+		"41,29,PM10,30,2024-01-15T10:30:00Z\n" +
+		"41,29,PM10,31,2024-01-15T11:30:00Z\n"
+
+	failure := errors.New("broker unavailable")
+	calls := 0
+	summary, err := NewImporter(DefaultColumnMapping(), func(models.AirQualityPayload) error {
+		calls++
+		return failure
+	}).Import(strings.NewReader(csv))
+
+	if !errors.Is(err, failure) {
+		t.Errorf("Import error = %v, want %v", err, failure)
+	}
+	if calls != 1 || summary.Accepted != 0 {
+		t.Errorf("published %d rows with summary %+v, want to stop after the first", calls, summary)
+	}
+}
@@ -0,0 +1,193 @@
+package grpcserver
+
+import (
+	"api/internal/codec"
+	"api/internal/grpc/ingestpb"
+	"api/internal/ingest"
+	"api/internal/signing"
+	"api/internal/validation"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type Server struct {
+	ingestpb.UnimplementedIngestServiceServer
+	Pipeline *ingest.Pipeline
+	Verifier *signing.Verifier
+}
+
+func NewServer(pipeline *ingest.Pipeline, verifier *signing.Verifier) *Server {
+	return &Server{
+		Pipeline: pipeline,
+		Verifier: verifier,
+	}
+}
+
+func (s *Server) StartGrpcServer(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	ingestpb.RegisterIngestServiceServer(server, s)
+
+	log.Println("Starting gRPC server on", addr)
+	return server.Serve(lis)
+}
+
+// Ingest queues one reading. Sensors sign it like an HTTP reading, sending
+// the X-Sensor-Id, X-Signature-Timestamp, X-Signature-Nonce and X-Signature
+// headers as metadata; the signed body is the deterministic protobuf
+// encoding of the Reading.
+func (s *Server) Ingest(ctx context.Context, reading *ingestpb.Reading) (*ingestpb.Ack, error) {
+	ack, err := s.ingest(signedMessage(ctx, reading), reading, 0)
+	if err == nil {
+		return ack, nil
+	}
+
+	var throttle *ingest.ThrottleError
+	switch {
+	case errors.As(err, &throttle):
+		seconds := int(math.Ceil(throttle.RetryAfter.Seconds()))
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+		if errors.Is(err, ingest.ErrRateLimited) {
+			return nil, status.Error(codes.ResourceExhausted, ack.Error)
+		}
+		return nil, status.Error(codes.Unavailable, ack.Error)
+	case errors.Is(err, signing.ErrSignature):
+		return nil, status.Error(codes.Unauthenticated, ack.Error)
+	case errors.Is(err, validation.ErrInvalidPayload):
+		return nil, status.Error(codes.InvalidArgument, ack.Error)
+	case errors.Is(err, ingest.ErrDuplicate):
+		return nil, status.Error(codes.AlreadyExists, ack.Error)
+	default:
+		return nil, status.Error(codes.Unavailable, ack.Error)
+	}
+}
+
+// IngestStream consumes readings until the client closes the stream and
+// answers with one acknowledgement per reading, in the order received. A bad
+// reading does not abort the stream; the client resends only the readings
+// whose ack is FAILED or THROTTLED (after the ack's retry_after).
+func (s *Server) IngestStream(stream ingestpb.IngestService_IngestStreamServer) error {
+	response := &ingestpb.IngestStreamResponse{}
+
+	for sequence := uint64(0); ; sequence++ {
+		reading, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(response)
+		}
+		if err != nil {
+			return err
+		}
+
+		ack, _ := s.ingest(unsignedMessage(stream.Context(), reading), reading, sequence)
+		switch ack.Status {
+		case ingestpb.AckStatus_ACK_STATUS_ACCEPTED:
+			response.Accepted++
+		case ingestpb.AckStatus_ACK_STATUS_REJECTED:
+			response.Rejected++
+		case ingestpb.AckStatus_ACK_STATUS_DUPLICATE:
+			response.Duplicates++
+		case ingestpb.AckStatus_ACK_STATUS_THROTTLED:
+			response.Throttled++
+		default:
+			response.Failed++
+		}
+		response.Acks = append(response.Acks, ack)
+	}
+}
+
+// unsignedMessage describes a reading that carries no signature. Streamed
+// readings cannot be signed one by one, so readings from sensors that must
+// sign are rejected on the stream.
+func unsignedMessage(ctx context.Context, reading *ingestpb.Reading) signing.Message {
+	message := signing.Message{SensorID: reading.GetSensorId(), Transport: "grpc"}
+	if p, ok := peer.FromContext(ctx); ok {
+		message.RemoteAddr = p.Addr.String()
+	}
+	return message
+}
+
+// signedMessage reads the signature of a unary call from its metadata.
+func signedMessage(ctx context.Context, reading *ingestpb.Reading) signing.Message {
+	message := unsignedMessage(ctx, reading)
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	message.Signature = header(signing.HeaderSignature)
+	if !message.Signed() {
+		return message
+	}
+	message.SensorID = header(signing.HeaderSensorID)
+	message.Timestamp = header(signing.HeaderTimestamp)
+	message.Nonce = header(signing.HeaderNonce)
+	message.Body, _ = proto.MarshalOptions{Deterministic: true}.Marshal(reading)
+	return message
+}
+
+// ingest runs one reading through the pipeline once its signature, or the
+// lack of one, has been checked as on the HTTP endpoint.
+func (s *Server) ingest(message signing.Message, reading *ingestpb.Reading, sequence uint64) (*ingestpb.Ack, error) {
+	ack := &ingestpb.Ack{
+		Sequence:  sequence,
+		MessageId: reading.GetMessageId(),
+		Status:    ingestpb.AckStatus_ACK_STATUS_ACCEPTED,
+	}
+
+	err := s.Verifier.Verify(message)
+	if err == nil {
+		err = s.Verifier.CheckSensor(message, reading.GetSensorId())
+	}
+	if err != nil {
+		ack.Status = ingestpb.AckStatus_ACK_STATUS_REJECTED
+		if !errors.Is(err, signing.ErrSignature) {
+			ack.Status = ingestpb.AckStatus_ACK_STATUS_FAILED
+		}
+		ack.Error = err.Error()
+		return ack, err
+	}
+
+	payload := codec.PayloadFromReading(reading)
+	if message.Signed() {
+		payload.SensorID = message.SensorID
+	}
+	payload, err = s.Pipeline.Ingest(payload)
+	ack.MessageId = payload.MessageID
+	if err != nil {
+		var throttle *ingest.ThrottleError
+		switch {
+		case errors.Is(err, validation.ErrInvalidPayload):
+			ack.Status = ingestpb.AckStatus_ACK_STATUS_REJECTED
+		case errors.Is(err, ingest.ErrDuplicate):
+			ack.Status = ingestpb.AckStatus_ACK_STATUS_DUPLICATE
+		case errors.As(err, &throttle):
+			ack.Status = ingestpb.AckStatus_ACK_STATUS_THROTTLED
+			ack.RetryAfter = durationpb.New(throttle.RetryAfter)
+		default:
+			ack.Status = ingestpb.AckStatus_ACK_STATUS_FAILED
+		}
+		ack.Error = err.Error()
+	}
+
+	return ack, err
+}
@@ -0,0 +1,74 @@
+package idempotency
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const DefaultWindow = 10 * time.Minute
+
+// WindowFromEnv reads IDEMPOTENCY_WINDOW (a Go duration such as "10m");
+// "0" disables duplicate detection.
+func WindowFromEnv() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_WINDOW"))
+	if err != nil || window < 0 {
+		return DefaultWindow
+	}
+	return window
+}
+
+// Cache remembers message IDs seen within the window.
+type Cache struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewCache(window time.Duration) *Cache {
+	return &Cache{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Add records the ID and reports whether it was not already seen within the
+// window. A zero window or an empty ID always counts as new.
+func (c *Cache) Add(id string) bool {
+	if c == nil || c.window == 0 || id == "" {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.window {
+		for key, seenAt := range c.seen {
+			if now.Sub(seenAt) > c.window {
+				delete(c.seen, key)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if seenAt, ok := c.seen[id]; ok && now.Sub(seenAt) <= c.window {
+		return false
+	}
+	c.seen[id] = now
+	return true
+}
+
+// Remove forgets an ID, e.g. when publishing failed and the client should
+// be allowed to retry.
+func (c *Cache) Remove(id string) {
+	if c == nil || id == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, id)
+}
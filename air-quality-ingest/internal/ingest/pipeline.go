@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"api/internal/idempotency"
+	"api/internal/models"
+	"api/internal/queue"
+	"api/internal/ratelimit"
+	"api/internal/validation"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	ErrDuplicate   = errors.New("duplicate message")
+	ErrRateLimited = errors.New("rate limit exceeded")
+	ErrOverloaded  = errors.New("processor is falling behind")
+)
+
+// ThrottleError wraps ErrRateLimited or ErrOverloaded with the time the
+// client should wait before retrying.
+type ThrottleError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline is the path every reading takes regardless of the transport it
+// arrived on: validation and normalisation, backpressure, duplicate
+// detection, then publishing to the queue.
+type Pipeline struct {
+	Queue   *queue.Queue
+	Dedupe  *idempotency.Cache
+	Limiter *ratelimit.Limiter
+	Backlog *queue.DepthMonitor
+}
+
+func NewPipeline(queue *queue.Queue, dedupe *idempotency.Cache, limiter *ratelimit.Limiter, backlog *queue.DepthMonitor) *Pipeline {
+	return &Pipeline{
+		Queue:   queue,
+		Dedupe:  dedupe,
+		Limiter: limiter,
+		Backlog: backlog,
+	}
+}
+
+// NewPipelineFromEnv connects a pipeline to the broker, configured from the
+// environment like the ingest service, and starts watching the backlog.
+func NewPipelineFromEnv(conn *amqp.Connection) *Pipeline {
+	backlog := queue.NewDepthMonitorFromEnv(conn)
+	go backlog.Start()
+
+	return NewPipeline(
+		queue.NewQueue(conn),
+		idempotency.NewCache(idempotency.WindowFromEnv()),
+		ratelimit.NewLimiter(ratelimit.ConfigFromEnv()),
+		backlog,
+	)
+}
+
+// Ingest returns a validation.ErrInvalidPayload error for readings that were
+// rejected, ErrDuplicate when the message ID was already ingested within the
+// idempotency window and a *ThrottleError when the device or the whole
+// service is over its rate limit or the queue backlog is too deep; any other
+// error means publishing failed. The normalised payload is returned so
+// callers can report its message ID.
+func (p *Pipeline) Ingest(payload models.AirQualityPayload) (models.AirQualityPayload, error) {
+	return p.ingest(payload, true)
+}
+
+// IngestBulk is Ingest for bulk imports (CSV, OpenAQ, the import commands),
+// which replay a station's history far faster than the station itself
+// reports: it skips the per-device bucket but keeps the global bucket and
+// the backlog check.
+func (p *Pipeline) IngestBulk(payload models.AirQualityPayload) (models.AirQualityPayload, error) {
+	return p.ingest(payload, false)
+}
+
+// IngestBulkWait is IngestBulk for callers with no client to hand a
+// Retry-After to, such as the import commands: it waits out throttling
+// instead of returning it.
+func (p *Pipeline) IngestBulkWait(payload models.AirQualityPayload) (models.AirQualityPayload, error) {
+	for {
+		normalized, err := p.IngestBulk(payload)
+		var throttled *ThrottleError
+		if !errors.As(err, &throttled) {
+			return normalized, err
+		}
+		time.Sleep(throttled.RetryAfter)
+	}
+}
+
+func (p *Pipeline) ingest(payload models.AirQualityPayload, limitDevice bool) (models.AirQualityPayload, error) {
+	if err := validation.NormalizePayload(&payload); err != nil {
+		return payload, err
+	}
+
+	if err := p.CheckBacklog(); err != nil {
+		return payload, err
+	}
+
+	deviceID := ""
+	if limitDevice {
+		deviceID = DeviceID(payload)
+	}
+	if ok, retryAfter := p.Limiter.Allow(deviceID); !ok {
+		return payload, &ThrottleError{Err: ErrRateLimited, RetryAfter: retryAfter}
+	}
+
+	if !p.Dedupe.Add(payload.MessageID) {
+		return payload, ErrDuplicate
+	}
+
+	if err := p.Queue.PublishToQueue(payload); err != nil {
+		p.Dedupe.Remove(payload.MessageID)
+		return payload, err
+	}
+
+	return payload, nil
+}
+
+// CheckBacklog returns a *ThrottleError wrapping ErrOverloaded when the
+// measurements queue is deeper than allowed.
+func (p *Pipeline) CheckBacklog() error {
+	if overloaded, retryAfter := p.Backlog.Overloaded(); overloaded {
+		return &ThrottleError{Err: ErrOverloaded, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// DeviceID identifies the sender for per-device rate limits: the sensor ID,
+// or the reading's coordinates for sensors that do not send one.
+func DeviceID(payload models.AirQualityPayload) string {
+	if payload.SensorID != "" {
+		return payload.SensorID
+	}
+	return fmt.Sprintf("%.4f,%.4f", payload.Latitude, payload.Longitude)
+}
@@ -0,0 +1,167 @@
+package lineprotocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrSyntax = errors.New("line protocol syntax error")
+
+// Point is one parsed line of InfluxDB line protocol. Only numeric fields
+// are kept; string and boolean fields carry no reading and are dropped.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Timestamp   time.Time
+}
+
+// Precision multipliers for the ?precision= query parameter, in nanoseconds.
+var precisions = map[string]int64{
+	"":   1,
+	"n":  1,
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"us": int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+func ValidPrecision(precision string) bool {
+	_, ok := precisions[precision]
+	return ok
+}
+
+// ParseLine parses a single non-empty, non-comment line. Lines without a
+// timestamp are stamped with now.
+func ParseLine(line, precision string, now time.Time) (Point, error) {
+	point := Point{
+		Tags:   map[string]string{},
+		Fields: map[string]float64{},
+	}
+
+	seriesKey, rest, ok := cutUnescaped(line, ' ', false)
+	if !ok {
+		return point, fmt.Errorf("%w: missing fields", ErrSyntax)
+	}
+
+	parts := splitUnescaped(seriesKey, ',', false)
+	point.Measurement = unescape(parts[0])
+	if point.Measurement == "" {
+		return point, fmt.Errorf("%w: missing measurement", ErrSyntax)
+	}
+	for _, tag := range parts[1:] {
+		key, value, ok := cutUnescaped(tag, '=', false)
+		if !ok || key == "" || value == "" {
+			return point, fmt.Errorf("%w: invalid tag %q", ErrSyntax, tag)
+		}
+		point.Tags[unescape(key)] = unescape(value)
+	}
+
+	fieldSet, timestamp, _ := cutUnescaped(strings.TrimLeft(rest, " "), ' ', true)
+	if fieldSet == "" {
+		return point, fmt.Errorf("%w: missing fields", ErrSyntax)
+	}
+	for _, field := range splitUnescaped(fieldSet, ',', true) {
+		key, value, ok := cutUnescaped(field, '=', false)
+		if !ok || key == "" || value == "" {
+			return point, fmt.Errorf("%w: invalid field %q", ErrSyntax, field)
+		}
+		number, isNumber, err := parseFieldValue(value)
+		if err != nil {
+			return point, fmt.Errorf("%w: field %q: %v", ErrSyntax, unescape(key), err)
+		}
+		if isNumber {
+			point.Fields[unescape(key)] = number
+		}
+	}
+
+	timestamp = strings.TrimSpace(timestamp)
+	if timestamp == "" {
+		point.Timestamp = now
+		return point, nil
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return point, fmt.Errorf("%w: invalid timestamp %q", ErrSyntax, timestamp)
+	}
+	multiplier, ok := precisions[precision]
+	if !ok {
+		return point, fmt.Errorf("%w: invalid precision %q", ErrSyntax, precision)
+	}
+	if ts > math.MaxInt64/multiplier || ts < math.MinInt64/multiplier {
+		return point, fmt.Errorf("%w: timestamp %q out of range for precision %q", ErrSyntax, timestamp, precision)
+	}
+	point.Timestamp = time.Unix(0, ts*multiplier).UTC()
+
+	return point, nil
+}
+
+func parseFieldValue(value string) (float64, bool, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return 0, false, errors.New("unterminated string")
+		}
+		return 0, false, nil
+	case value == "t" || value == "T" || value == "true" || value == "True" || value == "TRUE",
+		value == "f" || value == "F" || value == "false" || value == "False" || value == "FALSE":
+		return 0, false, nil
+	case strings.HasSuffix(value, "i"):
+		n, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		return float64(n), true, err
+	case strings.HasSuffix(value, "u"):
+		n, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		return float64(n), true, err
+	default:
+		n, err := strconv.ParseFloat(value, 64)
+		return n, true, err
+	}
+}
+
+// cutUnescaped splits s around the first sep that is not escaped with a
+// backslash (and, when quotes is set, not inside a double-quoted string).
+func cutUnescaped(s string, sep byte, quotes bool) (string, string, bool) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	for {
+		before, after, ok := cutUnescaped(s, sep, quotes)
+		parts = append(parts, before)
+		if !ok {
+			return parts
+		}
+		s = after
+	}
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, ="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package lineprotocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		line      string
+		precision string
+		want      Point
+	}{
+		{
+			name: "tags, fields and nanosecond timestamp",
+			line: "air,sensor=ST-001,lat=41.01,lon=28.97 pm25=12.5,no2=40i 1705314600000000000",
+			want: Point{
+				Measurement: "air",
+				Tags:        map[string]string{"sensor": "ST-001", "lat": "41.01", "lon": "28.97"},
+				Fields:      map[string]float64{"pm25": 12.5, "no2": 40},
+				Timestamp:   time.Unix(1705314600, 0).UTC(),
+			},
+		},
+		{
+			name:      "escaped spaces, commas and equals signs",
+			line:      `air\ quality,site=Kad\,ıköy\=1,lat=41,lon=29 pm\ 10=3 1705314600`,
+			precision: "s",
+			want: Point{
+				Measurement: "air quality",
+				Tags:        map[string]string{"site": "Kad,ıköy=1", "lat": "41", "lon": "29"},
+				Fields:      map[string]float64{"pm 10": 3},
+				Timestamp:   time.Unix(1705314600, 0).UTC(),
+			},
+		},
+		{
+			name:      "quoted string field with separators is dropped",
+			line:      `air,lat=1,lon=2 note="say \"hi\", then go",pm25=3 1705314600000`,
+			precision: "ms",
+			want: Point{
+				Measurement: "air",
+				Tags:        map[string]string{"lat": "1", "lon": "2"},
+				Fields:      map[string]float64{"pm25": 3},
+				Timestamp:   time.Unix(1705314600, 0).UTC(),
+			},
+		},
+		{
+			name:      "boolean fields are dropped, unsigned kept",
+			line:      "air pm10=7u,online=t,charging=FALSE 1705314600000000",
+			precision: "us",
+			want: Point{
+				Measurement: "air",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"pm10": 7},
+				Timestamp:   time.Unix(1705314600, 0).UTC(),
+			},
+		},
+		{
+			name: "missing timestamp is stamped with now",
+			line: "air o3=80.25",
+			want: Point{
+				Measurement: "air",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"o3": 80.25},
+				Timestamp:   now,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line, tt.precision, now)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) returned error: %v", tt.line, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		precision string
+	}{
+		{"no fields", "air", ""},
+		{"empty field set", "air,lat=1 ", ""},
+		{"missing measurement", ",lat=1 pm25=1", ""},
+		{"tag without value", "air,lat pm25=1", ""},
+		{"field without value", "air pm25=", ""},
+		{"unterminated string", `air note="open`, ""},
+		{"lone quote", `air note="`, ""},
+		{"non-numeric field", "air pm25=high", ""},
+		{"invalid integer", "air pm25=1.5i", ""},
+		{"invalid timestamp", "air pm25=1 yesterday", ""},
+		{"unknown precision", "air pm25=1 1705314600", "h"},
+		{"timestamp overflows precision", "air pm25=1 9223372036854775807", "s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLine(tt.line, tt.precision, time.Now())
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("ParseLine(%q) error = %v, want ErrSyntax", tt.line, err)
+			}
+		})
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	point := Point{
+		Measurement: "air",
+		Tags:        map[string]string{"sensor_id": "ST-001", "latitude": "41.01", "lng": "28.97", "unit": "ppb", "parameter": "so2"},
+		Fields:      map[string]float64{"pm25": 12.5, "value": 4, "temperature": 21},
+		Timestamp:   time.Unix(1705314600, 0).UTC(),
+	}
+
+	payloads, err := Payloads(point)
+	if err != nil {
+		t.Fatalf("Payloads returned error: %v", err)
+	}
+
+	got := map[string]float64{}
+	for _, payload := range payloads {
+		if payload.SensorID != "ST-001" || payload.Latitude != 41.01 || payload.Longitude != 28.97 || payload.Unit != "ppb" {
+			t.Errorf("payload %+v does not carry the point's tags", payload)
+		}
+		got[payload.Parameter] = payload.Value
+	}
+	want := map[string]float64{"pm25": 12.5, "so2": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Payloads readings = %v, want %v", got, want)
+	}
+}
+
+func TestPayloadsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+	}{
+		{"missing latitude", map[string]string{"lon": "29"}},
+		{"invalid longitude", map[string]string{"lat": "41", "lon": "east"}},
+		{"no pollutant fields", map[string]string{"lat": "41", "lon": "29"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			point := Point{Measurement: "air", Tags: tt.tags, Fields: map[string]float64{"humidity": 40}}
+			if _, err := Payloads(point); err == nil {
+				t.Errorf("Payloads(%v) returned no error", tt.tags)
+			}
+		})
+	}
+}
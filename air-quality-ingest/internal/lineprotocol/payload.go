@@ -0,0 +1,87 @@
+package lineprotocol
+
+import (
+	"api/internal/models"
+	"api/internal/validation"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+var (
+	latitudeTags  = []string{"lat", "latitude"}
+	longitudeTags = []string{"lon", "lng", "longitude"}
+	sensorTags    = []string{"sensor", "sensor_id", "sensorId", "device", "host"}
+)
+
+// Payloads maps a point onto one reading per pollutant field. Location comes
+// from the lat/lon tags, the sensor ID from the sensor tag, and a "unit" tag
+// applies to every field. Fields that are not pollutants (temperature,
+// humidity, battery...) are ignored. A generic "value" field is accepted
+// when the pollutant is given in a "parameter" tag.
+func Payloads(point Point) ([]models.AirQualityPayload, error) {
+	latitude, err := floatTag(point.Tags, latitudeTags)
+	if err != nil {
+		return nil, err
+	}
+	longitude, err := floatTag(point.Tags, longitudeTags)
+	if err != nil {
+		return nil, err
+	}
+
+	base := models.AirQualityPayload{
+		SensorID:  tag(point.Tags, sensorTags),
+		Latitude:  latitude,
+		Longitude: longitude,
+		Unit:      point.Tags["unit"],
+		Timestamp: point.Timestamp,
+	}
+
+	keys := make([]string, 0, len(point.Fields))
+	for key := range point.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payloads []models.AirQualityPayload
+	for _, key := range keys {
+		parameter := key
+		if key == "value" {
+			parameter = point.Tags["parameter"]
+		}
+		if _, ok := validation.NormalizeParameter(parameter); !ok {
+			continue
+		}
+
+		payload := base
+		payload.Parameter = parameter
+		payload.Value = point.Fields[key]
+		payloads = append(payloads, payload)
+	}
+
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("measurement %q has no pollutant fields", point.Measurement)
+	}
+	return payloads, nil
+}
+
+func tag(tags map[string]string, names []string) string {
+	for _, name := range names {
+		if value, ok := tags[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func floatTag(tags map[string]string, names []string) (float64, error) {
+	value := tag(tags, names)
+	if value == "" {
+		return 0, fmt.Errorf("missing %s tag", names[0])
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s tag %q", names[0], value)
+	}
+	return number, nil
+}
@@ -3,9 +3,12 @@ package models
 import "time"
 
 type AirQualityPayload struct {
+	MessageID string    `json:"messageId,omitempty"`
+	SensorID  string    `json:"sensorId,omitempty"`
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 	Parameter string    `json:"parameter"`
 	Value     float64   `json:"value"`
+	Unit      string    `json:"unit,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 }
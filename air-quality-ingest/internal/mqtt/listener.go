@@ -0,0 +1,372 @@
+package mqttlistener
+
+import (
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/repository"
+	"api/internal/signing"
+	"api/internal/validation"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	idPlaceholder        = "{id}"
+	parameterPlaceholder = "{parameter}"
+	latitudePlaceholder  = "{lat}"
+	longitudePlaceholder = "{lon}"
+	maxBacklogRetries    = 5
+	connectTimeout       = 30 * time.Second
+)
+
+type Config struct {
+	BrokerURL string
+	ClientID  string
+	Username  string
+	Password  string
+	Topics    []string
+	QoS       byte
+}
+
+// ConfigFromEnv reads MQTT_BROKER_URL, MQTT_TOPICS (comma separated
+// patterns), MQTT_CLIENT_ID, MQTT_USERNAME, MQTT_PASSWORD and MQTT_QOS.
+// The listener is disabled when MQTT_BROKER_URL is empty.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		BrokerURL: os.Getenv("MQTT_BROKER_URL"),
+		ClientID:  os.Getenv("MQTT_CLIENT_ID"),
+		Username:  os.Getenv("MQTT_USERNAME"),
+		Password:  os.Getenv("MQTT_PASSWORD"),
+		Topics:    []string{"sensors/{id}/{parameter}"},
+		QoS:       1,
+	}
+	if cfg.BrokerURL == "" {
+		return cfg, false
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "air-quality-ingest"
+	}
+	if topics := os.Getenv("MQTT_TOPICS"); topics != "" {
+		cfg.Topics = nil
+		for _, topic := range strings.Split(topics, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				cfg.Topics = append(cfg.Topics, topic)
+			}
+		}
+	}
+	if qos, err := strconv.Atoi(os.Getenv("MQTT_QOS")); err == nil && qos >= 0 && qos <= 2 {
+		cfg.QoS = byte(qos)
+	}
+	return cfg, true
+}
+
+// Listener forwards MQTT readings into the pipeline. Devices, when set,
+// supplies the location of sensors that publish bare values.
+type Listener struct {
+	Pipeline *ingest.Pipeline
+	Verifier *signing.Verifier
+	Devices  *repository.DeviceRepository
+	Config   Config
+	client   mqtt.Client
+
+	rejected atomic.Int64
+	unacked  atomic.Int64
+}
+
+func NewListener(pipeline *ingest.Pipeline, verifier *signing.Verifier, devices *repository.DeviceRepository, config Config) *Listener {
+	return &Listener{
+		Pipeline: pipeline,
+		Verifier: verifier,
+		Devices:  devices,
+		Config:   config,
+	}
+}
+
+// signedEnvelope wraps a signed reading. MQTT 3.1.1 has no message headers,
+// so the signature fields travel next to the base64 encoded body they sign.
+type signedEnvelope struct {
+	SensorID  string          `json:"sensorId"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"signature"`
+	Payload   string          `json:"payload"`
+}
+
+func (l *Listener) Start() error {
+	filters := make(map[string]byte, len(l.Config.Topics))
+	for _, pattern := range l.Config.Topics {
+		filters[subscriptionFilter(pattern)] = l.Config.QoS
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(l.Config.BrokerURL).
+		SetClientID(l.Config.ClientID).
+		SetUsername(l.Config.Username).
+		SetPassword(l.Config.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		// Messages are acknowledged only once they are queued or rejected
+		// for good; see handleMessage.
+		SetAutoAckDisabled(true)
+
+	// Subscriptions are renewed on every (re)connect so a broker restart does
+	// not silently stop ingestion.
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.SubscribeMultiple(filters, l.handleMessage)
+		if token.Wait() && token.Error() != nil {
+			log.Printf("MQTT subscribe error: %v", token.Error())
+			return
+		}
+		log.Printf("Subscribed to MQTT topics: %v", l.Config.Topics)
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Printf("MQTT connection lost: %v", err)
+	})
+
+	l.client = mqtt.NewClient(opts)
+	token := l.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		l.client.Disconnect(0)
+		return fmt.Errorf("timed out connecting to MQTT broker %s after %s", l.Config.BrokerURL, connectTimeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	return nil
+}
+
+func (l *Listener) Stop() {
+	if l.client != nil {
+		l.client.Disconnect(250)
+	}
+}
+
+// handleMessage acknowledges a message once it was queued or rejected for
+// good. One that could not be queued because the processor or the device
+// registry is unavailable is left unacknowledged, so the broker delivers
+// it again after the listener reconnects.
+func (l *Listener) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	err := l.ingest(msg)
+	var rejected *rejectedError
+	switch {
+	case err == nil:
+	case errors.As(err, &rejected):
+		log.Printf("Rejected MQTT message on %s (%d rejected so far): %v", msg.Topic(), l.rejected.Add(1), err)
+	default:
+		log.Printf("Failed to ingest MQTT message from %s, leaving it for redelivery (%d so far): %v", msg.Topic(), l.unacked.Add(1), err)
+		return
+	}
+	msg.Ack()
+}
+
+// rejectedError marks a message that will never be accepted.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+func reject(err error) error {
+	return &rejectedError{err: err}
+}
+
+// verified turns a verifier error into a rejection unless the registry could
+// not be read.
+func verified(err error) error {
+	if errors.Is(err, signing.ErrSignature) {
+		return reject(err)
+	}
+	return err
+}
+
+func (l *Listener) ingest(msg mqtt.Message) error {
+	message, err := unwrap(msg.Topic(), msg.Payload())
+	if err != nil {
+		return reject(err)
+	}
+	if message.Signed() {
+		if err := l.Verifier.Verify(message); err != nil {
+			return verified(err)
+		}
+	}
+
+	payload, err := l.decode(msg.Topic(), message.Body)
+	if err != nil {
+		return err
+	}
+
+	if message.Signed() {
+		err = l.Verifier.CheckSensor(message, payload.SensorID)
+		payload.SensorID = message.SensorID
+	} else {
+		message.SensorID = payload.SensorID
+		err = l.Verifier.Verify(message)
+	}
+	if err != nil {
+		return verified(err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		_, err := l.Pipeline.Ingest(payload)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, validation.ErrInvalidPayload) || errors.Is(err, ingest.ErrDuplicate) || errors.Is(err, ingest.ErrRateLimited) {
+			return reject(err)
+		}
+
+		// MQTT has no way to tell a sensor to back off, so when the
+		// processor is behind the handler waits instead, which stalls
+		// delivery from the broker until the backlog drains.
+		var throttle *ingest.ThrottleError
+		if errors.As(err, &throttle) && attempt < maxBacklogRetries {
+			time.Sleep(throttle.RetryAfter)
+			continue
+		}
+		return err
+	}
+}
+
+// unwrap extracts the signature fields from a signed envelope. Any other
+// body is returned as an unsigned message.
+func unwrap(topic string, body []byte) (signing.Message, error) {
+	message := signing.Message{Body: body, Transport: "mqtt", RemoteAddr: topic}
+
+	var envelope signedEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Signature == "" {
+		return message, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return message, fmt.Errorf("invalid signed payload: %w", err)
+	}
+
+	message.SensorID = envelope.SensorID
+	message.Timestamp = strings.Trim(string(envelope.Timestamp), `"`)
+	message.Nonce = envelope.Nonce
+	message.Signature = envelope.Signature
+	message.Body = data
+	return message, nil
+}
+
+// decode turns an MQTT message into a payload. The body is the same JSON
+// document accepted by /api/ingest; the sensor ID and parameter are taken
+// from the topic when the pattern contains {id} or {parameter}, so sensors
+// publishing to sensors/{id}/{parameter} may omit them from the body.
+//
+// Sensors that publish one topic per pollutant may send just the number
+// (sensors/{id}/pm25 → 12.3). Such a reading is taken at the time it
+// arrives, at the location given by {lat} and {lon} in the topic or, failing
+// that, registered for the sensor in the device registry.
+func (l *Listener) decode(topic string, body []byte) (models.AirQualityPayload, error) {
+	var payload models.AirQualityPayload
+	value, bare := bareValue(body)
+	if bare {
+		payload.Value = value
+	} else if err := json.Unmarshal(body, &payload); err != nil {
+		return payload, reject(fmt.Errorf("invalid payload, expected a JSON document or a number: %w", err))
+	}
+
+	located := false
+	for _, pattern := range l.Config.Topics {
+		values, ok := matchTopic(pattern, topic)
+		if !ok {
+			continue
+		}
+		if id, ok := values[idPlaceholder]; ok && payload.SensorID == "" {
+			payload.SensorID = id
+		}
+		if parameter, ok := values[parameterPlaceholder]; ok && payload.Parameter == "" {
+			payload.Parameter = parameter
+		}
+		latitude, errLat := strconv.ParseFloat(values[latitudePlaceholder], 64)
+		longitude, errLon := strconv.ParseFloat(values[longitudePlaceholder], 64)
+		if errLat == nil && errLon == nil {
+			payload.Latitude, payload.Longitude = latitude, longitude
+			located = true
+		}
+		break
+	}
+
+	if bare && !located {
+		if payload.SensorID == "" || l.Devices == nil {
+			return payload, reject(errors.New("a bare value needs {lat} and {lon} in the topic or a registered sensor location"))
+		}
+		latitude, longitude, found, err := l.Devices.GetDeviceLocation(payload.SensorID)
+		if err != nil {
+			return payload, err
+		}
+		if !found {
+			return payload, reject(fmt.Errorf("sensor %q has no registered location for its bare value", payload.SensorID))
+		}
+		payload.Latitude, payload.Longitude = latitude, longitude
+	}
+
+	return payload, nil
+}
+
+// bareValue reports whether a body is just a number.
+func bareValue(body []byte) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	return value, err == nil
+}
+
+// subscriptionFilter converts a topic pattern such as sensors/{id}/{parameter}
+// into the MQTT filter sensors/+/+.
+func subscriptionFilter(pattern string) string {
+	levels := strings.Split(pattern, "/")
+	for i, level := range levels {
+		if isPlaceholder(level) {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+func matchTopic(pattern, topic string) (map[string]string, bool) {
+	patternLevels := strings.Split(pattern, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	values := make(map[string]string)
+	for i, level := range patternLevels {
+		if level == "#" {
+			return values, true
+		}
+		if i >= len(topicLevels) {
+			return nil, false
+		}
+		switch {
+		case isPlaceholder(level):
+			values[level] = topicLevels[i]
+		case level == "+" || level == topicLevels[i]:
+		default:
+			return nil, false
+		}
+	}
+
+	return values, len(patternLevels) == len(topicLevels)
+}
+
+func isPlaceholder(level string) bool {
+	return strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}")
+}
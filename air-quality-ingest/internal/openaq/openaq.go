@@ -0,0 +1,280 @@
+package openaq
+
+import (
+	"api/internal/csvimport"
+	"api/internal/ingest"
+	"api/internal/models"
+	"api/internal/signing"
+	"api/internal/validation"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+var ErrInvalidFormat = errors.New("invalid openaq data")
+
+// Record is a single OpenAQ measurement as returned by the /v2/measurements
+// API and written to the JSON archives.
+type Record struct {
+	Location    string      `json:"location"`
+	Parameter   string      `json:"parameter"`
+	Value       float64     `json:"value"`
+	Unit        string      `json:"unit"`
+	Date        Date        `json:"date"`
+	Coordinates Coordinates `json:"coordinates"`
+}
+
+type Date struct {
+	UTC   string `json:"utc"`
+	Local string `json:"local,omitempty"`
+}
+
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ToPayload converts the record into a reading. It carries no message ID,
+// so the pipeline derives one from the coordinates, parameter and parsed
+// UTC time; a record imported twice, from a JSON and a CSV export alike,
+// hashes to the same ID and is dropped as a duplicate.
+func (r Record) ToPayload() (models.AirQualityPayload, error) {
+	timestamp, err := time.Parse(time.RFC3339, r.Date.UTC)
+	if err != nil {
+		return models.AirQualityPayload{}, fmt.Errorf("invalid date.utc %q", r.Date.UTC)
+	}
+
+	payload := models.AirQualityPayload{
+		Latitude:  r.Coordinates.Latitude,
+		Longitude: r.Coordinates.Longitude,
+		Parameter: r.Parameter,
+		Value:     r.Value,
+		Unit:      r.Unit,
+		Timestamp: timestamp,
+	}
+	if err := validation.NormalizePayload(&payload); err != nil {
+		return payload, err
+	}
+	return payload, nil
+}
+
+// Summary counts records whose reading was already ingested as duplicates.
+type Summary = csvimport.Summary
+
+type Importer struct {
+	Publish csvimport.PublishFunc
+}
+
+func NewImporter(publish csvimport.PublishFunc) *Importer {
+	return &Importer{
+		Publish: publish,
+	}
+}
+
+// Import reads OpenAQ measurements in the given format and publishes every
+// valid record that has not been imported before. JSON input may be a single
+// record, an array, an API response with a "results" array or newline
+// delimited records; errors are reported by record number. CSV errors are
+// reported by line number.
+func (i *Importer) Import(r io.Reader, format string) (Summary, error) {
+	summary := Summary{Errors: []csvimport.RowError{}}
+
+	switch format {
+	case FormatJSON:
+		return summary, i.importJSON(r, &summary)
+	case FormatCSV:
+		return summary, i.importCSV(r, &summary)
+	default:
+		return summary, fmt.Errorf("%w: unsupported format %q", ErrInvalidFormat, format)
+	}
+}
+
+func (i *Importer) handle(record Record, position int, summary *Summary) error {
+	payload, err := record.ToPayload()
+	if err != nil {
+		summary.Reject(position, err.Error())
+		return nil
+	}
+
+	err = i.Publish(payload)
+	switch {
+	case errors.Is(err, ingest.ErrDuplicate):
+		summary.Duplicates++
+	case errors.Is(err, validation.ErrInvalidPayload), errors.Is(err, signing.ErrSignature):
+		summary.Reject(position, err.Error())
+	case err != nil:
+		return fmt.Errorf("failed to publish record %d: %w", position, err)
+	default:
+		summary.Accepted++
+	}
+	return nil
+}
+
+func (i *Importer) importJSON(r io.Reader, summary *Summary) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	position := 0
+
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				if position == 0 {
+					return fmt.Errorf("%w: no records found", ErrInvalidFormat)
+				}
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+
+		records, err := decodeRecords(raw)
+		if err != nil {
+			position++
+			summary.Reject(position, err.Error())
+			continue
+		}
+
+		for _, record := range records {
+			position++
+			var rec Record
+			if err := json.Unmarshal(record, &rec); err != nil {
+				summary.Reject(position, err.Error())
+				continue
+			}
+			if err := i.handle(rec, position, summary); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func decodeRecords(raw json.RawMessage) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var records []json.RawMessage
+		err := json.Unmarshal(raw, &records)
+		return records, err
+	}
+
+	var envelope struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Results != nil {
+		return envelope.Results, nil
+	}
+	return []json.RawMessage{raw}, nil
+}
+
+// csvColumns lists the header names used by the different OpenAQ CSV exports
+// (the legacy website download, the v2 API and the S3 archive) per field.
+var csvColumns = map[string][]string{
+	"location":  {"location"},
+	"parameter": {"parameter"},
+	"value":     {"value"},
+	"unit":      {"unit", "units"},
+	"utc":       {"utc", "date.utc", "datetime"},
+	"latitude":  {"latitude", "lat", "coordinates.latitude"},
+	"longitude": {"longitude", "lon", "coordinates.longitude"},
+}
+
+func (i *Importer) importCSV(r io.Reader, summary *Summary) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+
+	positions := make(map[string]int, len(header))
+	for idx, name := range header {
+		positions[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = idx
+	}
+	columns := make(map[string]int, len(csvColumns))
+	for field, names := range csvColumns {
+		columns[field] = -1
+		for _, name := range names {
+			if idx, ok := positions[name]; ok {
+				columns[field] = idx
+				break
+			}
+		}
+	}
+	for _, field := range []string{"parameter", "value", "utc", "latitude", "longitude"} {
+		if columns[field] < 0 {
+			return fmt.Errorf("%w: csv header is missing %s column", ErrInvalidFormat, field)
+		}
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				summary.Reject(parseErr.StartLine, parseErr.Err.Error())
+				continue
+			}
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			idx := columns[name]
+			if idx < 0 || idx >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[idx])
+		}
+
+		record, err := recordFromCSV(field)
+		if err != nil {
+			summary.Reject(line, err.Error())
+			continue
+		}
+		if err := i.handle(record, line, summary); err != nil {
+			return err
+		}
+	}
+}
+
+func recordFromCSV(field func(string) string) (Record, error) {
+	value, err := strconv.ParseFloat(field("value"), 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid value %q", field("value"))
+	}
+	lat, err := strconv.ParseFloat(field("latitude"), 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid latitude %q", field("latitude"))
+	}
+	lon, err := strconv.ParseFloat(field("longitude"), 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid longitude %q", field("longitude"))
+	}
+
+	return Record{
+		Location:    field("location"),
+		Parameter:   field("parameter"),
+		Value:       value,
+		Unit:        field("unit"),
+		Date:        Date{UTC: field("utc")},
+		Coordinates: Coordinates{Latitude: lat, Longitude: lon},
+	}, nil
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DepthMonitor polls the message count of the measurements queue so the
+// ingest API can push back when the processor is falling behind, without a
+// broker round trip per request.
+type DepthMonitor struct {
+	QueueConn *amqp.Connection
+	MaxDepth  int
+	Interval  time.Duration
+	depth     atomic.Int64
+}
+
+// NewDepthMonitorFromEnv reads QUEUE_MAX_DEPTH (0 disables the check, default
+// 10000).
+func NewDepthMonitorFromEnv(queueConn *amqp.Connection) *DepthMonitor {
+	maxDepth, err := strconv.Atoi(os.Getenv("QUEUE_MAX_DEPTH"))
+	if err != nil || maxDepth < 0 {
+		maxDepth = 10000
+	}
+	return &DepthMonitor{
+		QueueConn: queueConn,
+		MaxDepth:  maxDepth,
+		Interval:  2 * time.Second,
+	}
+}
+
+func (m *DepthMonitor) Start() {
+	if m.MaxDepth == 0 {
+		return
+	}
+
+	var ch *amqp.Channel
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if ch == nil {
+			var err error
+			if ch, err = m.QueueConn.Channel(); err != nil {
+				log.Printf("Queue depth monitor: failed to open channel: %v", err)
+				continue
+			}
+		}
+
+		// Declaring with the publisher's arguments is idempotent and also works
+		// before the first reading created the queue.
+		q, err := ch.QueueDeclare(MeasurementsQueue, true, false, false, false, nil)
+		if err != nil {
+			// A failed declare closes the channel; reopen it next tick.
+			log.Printf("Queue depth monitor: failed to inspect %s: %v", MeasurementsQueue, err)
+			ch = nil
+			continue
+		}
+		m.depth.Store(int64(q.Messages))
+	}
+}
+
+func (m *DepthMonitor) Depth() int {
+	return int(m.depth.Load())
+}
+
+// Overloaded reports whether the backlog exceeds the limit and, if so, how
+// long clients should wait before retrying.
+func (m *DepthMonitor) Overloaded() (bool, time.Duration) {
+	if m == nil || m.MaxDepth == 0 || m.Depth() < m.MaxDepth {
+		return false, 0
+	}
+	return true, 5 * m.Interval
+}
@@ -2,11 +2,14 @@ package queue
 
 import (
 	"api/internal/models"
-	"encoding/json"
+
+	"contracts/messages"
 
 	"github.com/streadway/amqp"
 )
 
+const MeasurementsQueue = "mesurements"
+
 type Queue struct {
 	QueueConn *amqp.Connection
 }
@@ -17,6 +20,9 @@ func NewQueue(QueueConn *amqp.Connection) *Queue {
 	}
 }
 
+// PublishToQueue publishes a reading to the topic exchange as a versioned
+// measurement envelope. DeclareTopology must have run so the measurements
+// queue is bound.
 func (r *Queue) PublishToQueue(data models.AirQualityPayload) error {
 	ch, err := r.QueueConn.Channel()
 	if err != nil {
@@ -24,31 +30,28 @@ func (r *Queue) PublishToQueue(data models.AirQualityPayload) error {
 	}
 	defer ch.Close()
 
-	q, err := ch.QueueDeclare(
-		"mesurements",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return err
-	}
-
-	body, err := json.Marshal(data)
+	body, err := messages.EncodeReading(messages.SourceIngest, data.MessageID, messages.Reading{
+		SensorID:  data.SensorID,
+		Latitude:  data.Latitude,
+		Longitude: data.Longitude,
+		Parameter: data.Parameter,
+		Value:     data.Value,
+		Unit:      data.Unit,
+		Timestamp: data.Timestamp,
+	})
 	if err != nil {
 		return err
 	}
 
 	return ch.Publish(
-		"",
-		q.Name,
+		Exchange,
+		MeasurementRoutingKey(data),
 		false,
 		false,
 		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
+			ContentType:  messages.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
 		},
 	)
 }
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"api/internal/models"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// Exchange is the topic exchange readings and anomaly alerts are published
+// to. Readings use the routing key measurement.<parameter>.<region>, e.g.
+// measurement.pm25.sxk9, so additional consumers can bind their own queues
+// to any subset without taking messages from the processor.
+const (
+	Exchange            = "air_quality"
+	MeasurementsBinding = "measurement.#"
+
+	regionPrecision = 4
+)
+
+// DeclareTopology declares the exchange and the processor's queue with its
+// binding, plus any extra queues listed in QUEUE_BINDINGS. Extra queues are
+// given as queue=pattern pairs separated by commas, with several patterns
+// for one queue separated by "|", e.g.
+// "archive=measurement.#,critical-export=anomaly.critical.*|anomaly.high.*".
+func DeclareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(Exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", Exchange, err)
+	}
+
+	bindings := map[string][]string{MeasurementsQueue: {MeasurementsBinding}}
+	extra, err := parseBindings(os.Getenv("QUEUE_BINDINGS"))
+	if err != nil {
+		return err
+	}
+	for name, patterns := range extra {
+		bindings[name] = append(bindings[name], patterns...)
+	}
+
+	for name, patterns := range bindings {
+		if _, err := ch.QueueDeclare(name, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", name, err)
+		}
+		for _, pattern := range patterns {
+			if err := ch.QueueBind(name, pattern, Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %w", name, pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseBindings(value string) (map[string][]string, error) {
+	bindings := make(map[string][]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, patterns, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.TrimSpace(patterns) == "" {
+			return nil, fmt.Errorf("invalid QUEUE_BINDINGS entry %q", entry)
+		}
+		for _, pattern := range strings.Split(patterns, "|") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				bindings[name] = append(bindings[name], pattern)
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// MeasurementRoutingKey builds measurement.<parameter>.<region> for a
+// normalised reading.
+func MeasurementRoutingKey(data models.AirQualityPayload) string {
+	return "measurement." + RoutingWord(data.Parameter) + "." + Region(data.Latitude, data.Longitude)
+}
+
+// RoutingWord turns a parameter name into a single routing key word: lower
+// case without dots, so PM2.5 becomes pm25.
+func RoutingWord(parameter string) string {
+	return strings.ToLower(strings.NewReplacer(".", "", " ", "", "*", "", "#", "").Replace(parameter))
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Region is the geohash cell of the coordinates at precision 4 (roughly
+// 39 km x 20 km), used as the region word of routing keys.
+func Region(latitude, longitude float64) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bit, index, even := 0, 0, true
+	for hash.Len() < regionPrecision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if longitude >= mid {
+				index = index<<1 | 1
+				lonRange[0] = mid
+			} else {
+				index <<= 1
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if latitude >= mid {
+				index = index<<1 | 1
+				latRange[0] = mid
+			} else {
+				index <<= 1
+				latRange[1] = mid
+			}
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[index])
+			bit, index = 0, 0
+		}
+	}
+	return hash.String()
+}
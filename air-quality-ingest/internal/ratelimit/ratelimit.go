@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const deviceIdleTimeout = 10 * time.Minute
+
+type Config struct {
+	GlobalRate  float64
+	GlobalBurst int
+	DeviceRate  float64
+	DeviceBurst int
+}
+
+// ConfigFromEnv reads RATE_LIMIT_GLOBAL / RATE_LIMIT_GLOBAL_BURST and
+// RATE_LIMIT_DEVICE / RATE_LIMIT_DEVICE_BURST (requests per second and bucket
+// size). A rate of 0 disables that limit.
+func ConfigFromEnv() Config {
+	return Config{
+		GlobalRate:  envFloat("RATE_LIMIT_GLOBAL", 500),
+		GlobalBurst: int(envFloat("RATE_LIMIT_GLOBAL_BURST", 1000)),
+		DeviceRate:  envFloat("RATE_LIMIT_DEVICE", 1),
+		DeviceBurst: int(envFloat("RATE_LIMIT_DEVICE_BURST", 10)),
+	}
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+type device struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter applies a global token bucket and one token bucket per device.
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	global    *rate.Limiter
+	devices   map[string]*device
+	lastSweep time.Time
+}
+
+func NewLimiter(config Config) *Limiter {
+	l := &Limiter{
+		config:    config,
+		devices:   make(map[string]*device),
+		lastSweep: time.Now(),
+	}
+	if config.GlobalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(config.GlobalRate), max(config.GlobalBurst, 1))
+	}
+	return l
+}
+
+// Allow takes a token from the device's bucket and the global bucket. When
+// either is empty nothing is consumed and the wait until a token will be
+// available is returned, for use as Retry-After. An empty device ID takes
+// from the global bucket only.
+func (l *Limiter) Allow(deviceID string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	var deviceReservation *rate.Reservation
+	if limiter := l.deviceLimiter(deviceID, now); limiter != nil {
+		deviceReservation = limiter.ReserveN(now, 1)
+		if delay := deviceReservation.DelayFrom(now); delay > 0 {
+			deviceReservation.CancelAt(now)
+			return false, delay
+		}
+	}
+
+	if l.global != nil {
+		globalReservation := l.global.ReserveN(now, 1)
+		if delay := globalReservation.DelayFrom(now); delay > 0 {
+			globalReservation.CancelAt(now)
+			if deviceReservation != nil {
+				deviceReservation.CancelAt(now)
+			}
+			return false, delay
+		}
+	}
+
+	return true, 0
+}
+
+func (l *Limiter) deviceLimiter(deviceID string, now time.Time) *rate.Limiter {
+	if l.config.DeviceRate <= 0 || deviceID == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > deviceIdleTimeout {
+		for id, d := range l.devices {
+			if now.Sub(d.lastSeen) > deviceIdleTimeout {
+				delete(l.devices, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	d, ok := l.devices[deviceID]
+	if !ok {
+		d = &device{limiter: rate.NewLimiter(rate.Limit(l.config.DeviceRate), max(l.config.DeviceBurst, 1))}
+		l.devices[deviceID] = d
+	}
+	d.lastSeen = now
+	return d.limiter
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeviceKeys holds the verification keys registered for a sensor. A device
+// may have an HMAC secret, an Ed25519 public key or both.
+type DeviceKeys struct {
+	SensorID   string
+	HMACSecret []byte
+	PublicKey  ed25519.PublicKey
+	Enabled    bool
+}
+
+func (k DeviceKeys) HasKeys() bool {
+	return len(k.HMACSecret) > 0 || len(k.PublicKey) > 0
+}
+
+// SecurityEvent is a rejected signed (or unsigned but required to be signed)
+// reading, kept for security review.
+type SecurityEvent struct {
+	Time       time.Time
+	SensorID   string
+	Transport  string
+	RemoteAddr string
+	Reason     string
+	Nonce      string
+}
+
+type DeviceRepository struct {
+	Db *sql.DB
+}
+
+func NewDeviceRepository(db *sql.DB) *DeviceRepository {
+	return &DeviceRepository{
+		Db: db,
+	}
+}
+
+// GetDeviceKeys loads the keys registered for a sensor. The second result is
+// false when the sensor is not in the registry.
+func (r *DeviceRepository) GetDeviceKeys(sensorID string) (DeviceKeys, bool, error) {
+	keys := DeviceKeys{SensorID: sensorID}
+
+	var secret, publicKey sql.NullString
+	err := r.Db.QueryRow(`
+		SELECT hmac_secret, ed25519_public_key, enabled
+		FROM devices
+		WHERE sensor_id = $1
+	`, sensorID).Scan(&secret, &publicKey, &keys.Enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return keys, false, nil
+	}
+	if err != nil {
+		return keys, false, fmt.Errorf("failed to load device %s: %w", sensorID, err)
+	}
+
+	if secret.String != "" {
+		keys.HMACSecret = []byte(secret.String)
+	}
+	if publicKey.String != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey.String)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return keys, true, fmt.Errorf("device %s has an invalid Ed25519 public key", sensorID)
+		}
+		keys.PublicKey = key
+	}
+
+	return keys, true, nil
+}
+
+// GetDeviceLocation returns where a registered sensor stands. The third
+// result is false when the sensor is unknown or has no location.
+func (r *DeviceRepository) GetDeviceLocation(sensorID string) (float64, float64, bool, error) {
+	var latitude, longitude sql.NullFloat64
+	err := r.Db.QueryRow(`
+		SELECT latitude, longitude
+		FROM devices
+		WHERE sensor_id = $1
+	`, sensorID).Scan(&latitude, &longitude)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to load location of device %s: %w", sensorID, err)
+	}
+	return latitude.Float64, longitude.Float64, latitude.Valid && longitude.Valid, nil
+}
+
+func (r *DeviceRepository) SaveSecurityEvent(event SecurityEvent) error {
+	_, err := r.Db.Exec(`
+		INSERT INTO security_events (time, sensor_id, transport, remote_addr, reason, nonce)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, event.Time, event.SensorID, event.Transport, event.RemoteAddr, event.Reason, event.Nonce)
+	if err != nil {
+		return fmt.Errorf("failed to save security event: %w", err)
+	}
+	return nil
+}
+
+// RecordNonce stores a nonce of a sensor and reports whether it was new. A
+// nonce last seen more than retention ago counts as new again.
+func (r *DeviceRepository) RecordNonce(sensorID, nonce string, retention time.Duration) (bool, error) {
+	result, err := r.Db.Exec(`
+		INSERT INTO signature_nonces (sensor_id, nonce, seen_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (sensor_id, nonce) DO UPDATE
+			SET seen_at = EXCLUDED.seen_at
+			WHERE signature_nonces.seen_at < now() - make_interval(secs => $3)
+	`, sensorID, nonce, retention.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to record nonce of %s: %w", sensorID, err)
+	}
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record nonce of %s: %w", sensorID, err)
+	}
+	return recorded > 0, nil
+}
+
+// PurgeNonces deletes the nonces last seen more than retention ago.
+func (r *DeviceRepository) PurgeNonces(retention time.Duration) error {
+	_, err := r.Db.Exec(`
+		DELETE FROM signature_nonces
+		WHERE seen_at < now() - make_interval(secs => $1)
+	`, retention.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to purge nonces: %w", err)
+	}
+	return nil
+}
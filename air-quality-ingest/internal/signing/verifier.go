@@ -0,0 +1,318 @@
+package signing
+
+import (
+	"api/internal/idempotency"
+	"api/internal/repository"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSensorID  = "X-Sensor-Id"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+
+	DefaultWindow = 5 * time.Minute
+
+	maxNonceLength     = 128
+	keyCacheTTL        = time.Minute
+	noncePurgeInterval = time.Minute
+)
+
+var ErrSignature = errors.New("signature verification failed")
+
+type Config struct {
+	// Required rejects unsigned readings from every sensor. Otherwise only
+	// sensors with keys in the registry must sign.
+	Required bool
+	// Window is how far the signed timestamp may drift from the server clock.
+	Window time.Duration
+}
+
+// ConfigFromEnv reads SIGNATURE_REQUIRED and SIGNATURE_WINDOW (a Go
+// duration, default 5m).
+func ConfigFromEnv() Config {
+	cfg := Config{Window: DefaultWindow}
+	cfg.Required, _ = strconv.ParseBool(os.Getenv("SIGNATURE_REQUIRED"))
+	if window, err := time.ParseDuration(os.Getenv("SIGNATURE_WINDOW")); err == nil && window > 0 {
+		cfg.Window = window
+	}
+	return cfg
+}
+
+// Message is a reading as it arrived on the wire together with its
+// signature fields. Body is the exact byte sequence that was signed.
+type Message struct {
+	SensorID   string
+	Timestamp  string
+	Nonce      string
+	Signature  string
+	Body       []byte
+	Transport  string
+	RemoteAddr string
+}
+
+// Signed reports whether the message carries a signature at all.
+func (m Message) Signed() bool {
+	return m.Signature != ""
+}
+
+// SigningString is what the sensor signs: the unix timestamp, the nonce and
+// the body separated by newlines.
+func SigningString(timestamp, nonce string, body []byte) []byte {
+	data := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	data = append(data, timestamp...)
+	data = append(data, '\n')
+	data = append(data, nonce...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
+type cachedKeys struct {
+	keys      repository.DeviceKeys
+	found     bool
+	expiresAt time.Time
+}
+
+// Verifier checks sensor signatures against the device registry and rejects
+// replays: the timestamp must fall inside the window and each nonce is
+// accepted once per sensor. Nonces are kept in signature_nonces so every
+// instance sees them; Nonces is only used without a registry. Every
+// rejection is written to security_events.
+type Verifier struct {
+	Devices *repository.DeviceRepository
+	Config  Config
+	Nonces  *idempotency.Cache
+
+	mu         sync.Mutex
+	keys       map[string]cachedKeys
+	lastPurged time.Time
+}
+
+func NewVerifier(devices *repository.DeviceRepository, config Config) *Verifier {
+	return &Verifier{
+		Devices: devices,
+		Config:  config,
+		Nonces:  idempotency.NewCache(2 * config.Window),
+		keys:    make(map[string]cachedKeys),
+	}
+}
+
+// Verify accepts a signed message whose signature matches one of the
+// sensor's keys, and an unsigned message only when signatures are optional
+// and the sensor has no keys registered. For unsigned messages SensorID
+// must be the sensor ID the reading itself carries, otherwise a reading in
+// the name of a signing sensor would pass. A nil Verifier accepts
+// everything.
+func (v *Verifier) Verify(msg Message) error {
+	if v == nil {
+		return nil
+	}
+
+	if !msg.Signed() {
+		if v.Config.Required {
+			return v.reject(msg, "missing signature")
+		}
+		if msg.SensorID == "" {
+			return nil
+		}
+		keys, found, err := v.lookup(msg.SensorID)
+		if err != nil {
+			return err
+		}
+		if found && keys.HasKeys() {
+			return v.reject(msg, "missing signature")
+		}
+		return nil
+	}
+
+	if msg.SensorID == "" {
+		return v.reject(msg, "missing sensor ID")
+	}
+	if msg.Nonce == "" || len(msg.Nonce) > maxNonceLength {
+		return v.reject(msg, "missing or invalid nonce")
+	}
+
+	seconds, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+	if err != nil {
+		return v.reject(msg, "invalid timestamp")
+	}
+	if drift := time.Since(time.Unix(seconds, 0)); drift > v.Config.Window || drift < -v.Config.Window {
+		return v.reject(msg, "timestamp outside the allowed window")
+	}
+
+	keys, found, err := v.lookup(msg.SensorID)
+	if err != nil {
+		return err
+	}
+	if !found || !keys.HasKeys() {
+		return v.reject(msg, "unknown device")
+	}
+	if !keys.Enabled {
+		return v.reject(msg, "device disabled")
+	}
+
+	signature, ok := decodeSignature(msg.Signature)
+	if !ok {
+		return v.reject(msg, "malformed signature")
+	}
+	if !verifySignature(keys, SigningString(msg.Timestamp, msg.Nonce, msg.Body), signature) {
+		return v.reject(msg, "signature mismatch")
+	}
+
+	// The nonce is only recorded once the signature checks out so forged
+	// requests cannot burn a sensor's nonces.
+	fresh, err := v.recordNonce(msg)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return v.reject(msg, "replayed nonce")
+	}
+
+	return nil
+}
+
+// CheckSensor rejects a decoded reading whose sensor ID differs from the one
+// that signed it.
+func (v *Verifier) CheckSensor(msg Message, sensorID string) error {
+	if v == nil || !msg.Signed() || sensorID == "" || sensorID == msg.SensorID {
+		return nil
+	}
+	return v.reject(msg, fmt.Sprintf("payload sensor ID %q does not match signer", sensorID))
+}
+
+// recordNonce reports whether the message's nonce is new for its sensor.
+// A timestamp may be up to Window in the past or future, so a nonce has to
+// be remembered for twice that long.
+func (v *Verifier) recordNonce(msg Message) (bool, error) {
+	if v.Devices == nil {
+		return v.Nonces.Add(msg.SensorID + "|" + msg.Nonce), nil
+	}
+
+	retention := 2 * v.Config.Window
+	v.mu.Lock()
+	purge := time.Since(v.lastPurged) > noncePurgeInterval
+	if purge {
+		v.lastPurged = time.Now()
+	}
+	v.mu.Unlock()
+	if purge {
+		if err := v.Devices.PurgeNonces(retention); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return v.Devices.RecordNonce(msg.SensorID, msg.Nonce, retention)
+}
+
+func (v *Verifier) reject(msg Message, reason string) error {
+	log.Printf("Security: rejected reading from sensor %q via %s (%s): %s", msg.SensorID, msg.Transport, msg.RemoteAddr, reason)
+
+	if v.Devices != nil {
+		event := repository.SecurityEvent{
+			Time:       time.Now().UTC(),
+			SensorID:   msg.SensorID,
+			Transport:  msg.Transport,
+			RemoteAddr: msg.RemoteAddr,
+			Reason:     reason,
+			Nonce:      msg.Nonce,
+		}
+		if err := v.Devices.SaveSecurityEvent(event); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrSignature, reason)
+}
+
+// lookup reads a sensor's keys through a short-lived cache so unsigned
+// traffic does not hit the registry on every reading.
+func (v *Verifier) lookup(sensorID string) (repository.DeviceKeys, bool, error) {
+	if v.Devices == nil {
+		return repository.DeviceKeys{}, false, nil
+	}
+
+	v.mu.Lock()
+	cached, ok := v.keys[sensorID]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.keys, cached.found, nil
+	}
+
+	keys, found, err := v.Devices.GetDeviceKeys(sensorID)
+	if err != nil {
+		return keys, false, err
+	}
+
+	v.mu.Lock()
+	now := time.Now()
+	for id, entry := range v.keys {
+		if now.After(entry.expiresAt) {
+			delete(v.keys, id)
+		}
+	}
+	v.keys[sensorID] = cachedKeys{keys: keys, found: found, expiresAt: now.Add(keyCacheTTL)}
+	v.mu.Unlock()
+
+	return keys, found, nil
+}
+
+func verifySignature(keys repository.DeviceKeys, data, signature []byte) bool {
+	if len(keys.HMACSecret) > 0 {
+		mac := hmac.New(sha256.New, keys.HMACSecret)
+		mac.Write(data)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+	if len(keys.PublicKey) > 0 && len(signature) == ed25519.SignatureSize {
+		return ed25519.Verify(keys.PublicKey, data, signature)
+	}
+	return false
+}
+
+// decodeSignature accepts hex or (standard or URL-safe) base64, optionally
+// prefixed with the algorithm name as in "hmac-sha256=..." or "ed25519=...".
+func decodeSignature(value string) ([]byte, bool) {
+	value = strings.TrimSpace(value)
+	for _, prefix := range []string{"hmac-sha256=", "ed25519="} {
+		value = strings.TrimPrefix(value, prefix)
+	}
+
+	if decoded, err := hex.DecodeString(value); err == nil {
+		return decoded, true
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(value); err == nil {
+			return decoded, true
+		}
+	}
+	return nil, false
+}
+
+// MessageFromRequest collects the signature headers of an HTTP request.
+func MessageFromRequest(req *http.Request, body []byte) Message {
+	return Message{
+		SensorID:   req.Header.Get(HeaderSensorID),
+		Timestamp:  req.Header.Get(HeaderTimestamp),
+		Nonce:      req.Header.Get(HeaderNonce),
+		Signature:  req.Header.Get(HeaderSignature),
+		Body:       body,
+		Transport:  "http",
+		RemoteAddr: req.RemoteAddr,
+	}
+}
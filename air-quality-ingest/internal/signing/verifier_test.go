@@ -0,0 +1,160 @@
+package signing
+
+import (
+	"api/internal/repository"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("device-secret")
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := SigningString("1705314600", "n-1", []byte(`{"parameter":"PM2.5","value":12.5}`))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	hmacSignature := mac.Sum(nil)
+	edSignature := ed25519.Sign(privateKey, data)
+
+	tampered := func(signature []byte) []byte {
+		out := append([]byte(nil), signature...)
+		out[len(out)/2] ^= 0x01
+		return out
+	}
+	tamperedData := []byte(strings.Replace(string(data), "12.5", "1.5", 1))
+
+	hmacKeys := repository.DeviceKeys{HMACSecret: secret, Enabled: true}
+	edKeys := repository.DeviceKeys{PublicKey: publicKey, Enabled: true}
+	bothKeys := repository.DeviceKeys{HMACSecret: secret, PublicKey: publicKey, Enabled: true}
+
+	tests := []struct {
+		name      string
+		keys      repository.DeviceKeys
+		data      []byte
+		signature string
+		want      bool
+	}{
+		{"hmac hex", hmacKeys, data, hex.EncodeToString(hmacSignature), true},
+		{"hmac base64 with prefix", hmacKeys, data, "hmac-sha256=" + base64.StdEncoding.EncodeToString(hmacSignature), true},
+		{"ed25519 base64url", edKeys, data, base64.RawURLEncoding.EncodeToString(edSignature), true},
+		{"ed25519 hex with prefix", edKeys, data, "ed25519=" + hex.EncodeToString(edSignature), true},
+		{"ed25519 when both keys are registered", bothKeys, data, base64.StdEncoding.EncodeToString(edSignature), true},
+		{"hmac over tampered body", hmacKeys, tamperedData, hex.EncodeToString(hmacSignature), false},
+		{"ed25519 over tampered body", edKeys, tamperedData, hex.EncodeToString(edSignature), false},
+		{"tampered hmac signature", hmacKeys, data, hex.EncodeToString(tampered(hmacSignature)), false},
+		{"tampered ed25519 signature", edKeys, data, hex.EncodeToString(tampered(edSignature)), false},
+		{"truncated ed25519 signature", edKeys, data, hex.EncodeToString(edSignature[:32]), false},
+		{"hmac with wrong secret", repository.DeviceKeys{HMACSecret: []byte("other")}, data, hex.EncodeToString(hmacSignature), false},
+		{"ed25519 with another device's key", repository.DeviceKeys{PublicKey: otherPublicKey}, data, hex.EncodeToString(edSignature), false},
+		{"hmac signature against ed25519 key", edKeys, data, hex.EncodeToString(hmacSignature), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, ok := decodeSignature(tt.signature)
+			if !ok {
+				t.Fatalf("decodeSignature(%q) failed", tt.signature)
+			}
+			if got := verifySignature(tt.keys, tt.data, signature); got != tt.want {
+				t.Errorf("verifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSignatureMalformed(t *testing.T) {
+	for _, value := range []string{"not a signature!", "hmac-sha256=%%%", "ed25519=z"} {
+		if _, ok := decodeSignature(value); ok {
+			t.Errorf("decodeSignature(%q) accepted a malformed signature", value)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		required bool
+		msg      Message
+		wantErr  bool
+	}{
+		{"unsigned when optional", false, Message{SensorID: "ST-001"}, false},
+		{"unsigned when required", true, Message{SensorID: "ST-001"}, true},
+		{"missing sensor ID", false, Message{Timestamp: now, Nonce: "n", Signature: "00"}, true},
+		{"missing nonce", false, Message{SensorID: "ST-001", Timestamp: now, Signature: "00"}, true},
+		{"oversized nonce", false, Message{SensorID: "ST-001", Timestamp: now, Nonce: strings.Repeat("n", maxNonceLength+1), Signature: "00"}, true},
+		{"non-numeric timestamp", false, Message{SensorID: "ST-001", Timestamp: "yesterday", Nonce: "n", Signature: "00"}, true},
+		{"timestamp too old", false, Message{SensorID: "ST-001", Timestamp: stale, Nonce: "n", Signature: "00"}, true},
+		{"timestamp too far ahead", false, Message{SensorID: "ST-001", Timestamp: future, Nonce: "n", Signature: "00"}, true},
+		{"unregistered device", false, Message{SensorID: "ST-001", Timestamp: now, Nonce: "n", Signature: "00"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewVerifier(nil, Config{Required: tt.required, Window: DefaultWindow})
+			err := verifier.Verify(tt.msg)
+			if tt.wantErr && !errors.Is(err, ErrSignature) {
+				t.Errorf("Verify error = %v, want ErrSignature", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Verify returned error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordNonceRejectsReplays(t *testing.T) {
+	verifier := NewVerifier(nil, Config{Window: DefaultWindow})
+
+	steps := []struct {
+		sensor, nonce string
+		want          bool
+	}{
+		{"ST-001", "n-1", true},
+		{"ST-001", "n-1", false},
+		{"ST-001", "n-2", true},
+		{"ST-002", "n-1", true},
+	}
+	for _, step := range steps {
+		fresh, err := verifier.recordNonce(Message{SensorID: step.sensor, Nonce: step.nonce})
+		if err != nil {
+			t.Fatalf("recordNonce returned error: %v", err)
+		}
+		if fresh != step.want {
+			t.Errorf("recordNonce(%s, %s) = %v, want %v", step.sensor, step.nonce, fresh, step.want)
+		}
+	}
+}
+
+func TestCheckSensor(t *testing.T) {
+	verifier := NewVerifier(nil, Config{Window: DefaultWindow})
+	signed := Message{SensorID: "ST-001", Signature: "00"}
+
+	if err := verifier.CheckSensor(signed, "ST-001"); err != nil {
+		t.Errorf("CheckSensor with matching sensor returned error: %v", err)
+	}
+	if err := verifier.CheckSensor(signed, ""); err != nil {
+		t.Errorf("CheckSensor without payload sensor returned error: %v", err)
+	}
+	if err := verifier.CheckSensor(signed, "ST-002"); !errors.Is(err, ErrSignature) {
+		t.Errorf("CheckSensor impersonating another sensor error = %v, want ErrSignature", err)
+	}
+}
@@ -0,0 +1,127 @@
+package validation
+
+import (
+	"api/internal/models"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"contracts/messages"
+)
+
+const DefaultUnit = "µg/m³"
+
+// Molar masses (g/mol) used to convert gas concentrations from ppb/ppm to µg/m³
+// at 25°C and 1 atm, where one mole of gas occupies 24.45 litres.
+var molarMasses = map[string]float64{
+	"NO2": 46.0055,
+	"SO2": 64.066,
+	"O3":  47.9982,
+}
+
+const molarVolume = 24.45
+
+var parameters = map[string]string{
+	"PM2.5": "PM2.5",
+	"PM25":  "PM2.5",
+	"PM2_5": "PM2.5",
+	"PM10":  "PM10",
+	"NO2":   "NO2",
+	"SO2":   "SO2",
+	"O3":    "O3",
+}
+
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// NormalizePayload validates a reading before it is published and rewrites it
+// into the canonical form expected by the processor: upper-case parameter
+// names, values in µg/m³, a UTC timestamp (defaulting to now) and a message
+// ID used to drop duplicates.
+func NormalizePayload(payload *models.AirQualityPayload) error {
+	if math.IsNaN(payload.Latitude) || payload.Latitude < -90 || payload.Latitude > 90 {
+		return fmt.Errorf("%w: latitude must be between -90 and 90", ErrInvalidPayload)
+	}
+	if math.IsNaN(payload.Longitude) || payload.Longitude < -180 || payload.Longitude > 180 {
+		return fmt.Errorf("%w: longitude must be between -180 and 180", ErrInvalidPayload)
+	}
+
+	parameter, ok := NormalizeParameter(payload.Parameter)
+	if !ok {
+		return fmt.Errorf("%w: unsupported parameter %q", ErrInvalidPayload, payload.Parameter)
+	}
+	payload.Parameter = parameter
+
+	if math.IsNaN(payload.Value) || math.IsInf(payload.Value, 0) || payload.Value < 0 {
+		return fmt.Errorf("%w: value must be a non-negative number", ErrInvalidPayload)
+	}
+
+	value, err := convertUnit(parameter, payload.Value, payload.Unit)
+	if err != nil {
+		return err
+	}
+	payload.Value = value
+	payload.Unit = DefaultUnit
+
+	if payload.Timestamp.IsZero() {
+		payload.Timestamp = time.Now()
+	}
+	if payload.Timestamp.After(time.Now().Add(5 * time.Minute)) {
+		return fmt.Errorf("%w: timestamp is in the future", ErrInvalidPayload)
+	}
+	payload.Timestamp = payload.Timestamp.UTC()
+
+	payload.MessageID = strings.TrimSpace(payload.MessageID)
+	if payload.MessageID == "" {
+		payload.MessageID = DeriveMessageID(*payload)
+	}
+
+	return nil
+}
+
+// DeriveMessageID builds the idempotency key for readings sent without one:
+// a hash of the sensor ID, parameter and timestamp, so a retried reading
+// hashes to the same ID while two sensors reporting at the same instant do
+// not. Readings without a sensor ID are told apart by their coordinates,
+// hashed as a separate part so they never match a sensor ID.
+func DeriveMessageID(payload models.AirQualityPayload) string {
+	location := ""
+	if payload.SensorID == "" {
+		location = fmt.Sprintf("%.6f,%.6f", payload.Latitude, payload.Longitude)
+	}
+	return messages.DeriveMessageID(
+		messages.TypeMeasurement,
+		payload.SensorID,
+		location,
+		payload.Parameter,
+		payload.Timestamp.UTC().Format(time.RFC3339Nano),
+	)
+}
+
+// NormalizeParameter maps the spellings used by sensors and partner exports
+// ("pm2.5", "pm25", "no2", ...) to the names used throughout the pipeline.
+func NormalizeParameter(parameter string) (string, bool) {
+	name, ok := parameters[strings.ToUpper(strings.TrimSpace(parameter))]
+	return name, ok
+}
+
+func convertUnit(parameter string, value float64, unit string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "", "µg/m³", "µg/m3", "ug/m3", "ug/m³", "μg/m³", "μg/m3":
+		return value, nil
+	case "mg/m3", "mg/m³":
+		return value * 1000, nil
+	case "ppb", "ppm":
+		mass, ok := molarMasses[parameter]
+		if !ok {
+			return 0, fmt.Errorf("%w: unit %q is not valid for %s", ErrInvalidPayload, unit, parameter)
+		}
+		if strings.EqualFold(strings.TrimSpace(unit), "ppm") {
+			value *= 1000
+		}
+		return value * mass / molarVolume, nil
+	default:
+		return 0, fmt.Errorf("%w: unsupported unit %q", ErrInvalidPayload, unit)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
 	"api/internal/consumer"
+	"api/internal/outbox"
+	"api/internal/queue"
 	"api/pkg/db"
 
 	"github.com/joho/godotenv"
@@ -29,6 +32,11 @@ func main() {
 	}
 	defer conn.Close()
 
+	if err := queue.DeclareTopology(conn); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	Db := db.InitDB(dbURL)
 	defer Db.Close()
 
@@ -37,7 +45,15 @@ func main() {
 		Db:        Db,
 	}
 
-	consumer := consumer.NewConsumer(app.QueueConn, app.Db)
+	dedupeWindow, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_WINDOW"))
+	if err != nil || dedupeWindow < 0 {
+		dedupeWindow = 10 * time.Minute
+	}
+
+	relay := outbox.NewRelay(app.Db, app.QueueConn)
+	go relay.Start()
+
+	consumer := consumer.NewConsumer(app.QueueConn, app.Db, dedupeWindow)
 	consumer.StartConsumer()
 	fmt.Println("Consumer started")
 }
@@ -6,4 +6,9 @@ require github.com/joho/godotenv v1.5.1
 
 require github.com/streadway/amqp v1.1.0
 
-require github.com/lib/pq v1.10.9
+require (
+	contracts v0.0.0
+	github.com/lib/pq v1.10.9
+)
+
+replace contracts => ../contracts
@@ -97,18 +97,48 @@ func (d *Detector) fetchDataFromDB(ctx context.Context, parameter string, cutoff
 	return sum, count, nil
 }
 
-func (a *Detector) CheckTreshold(parameter string, value float64) bool {
-	thresholds := map[string]float64{
-		"PM2.5": 15.0,  // WHO 2021 24 saatlik ortalama sınır değeri
-		"PM10":  45.0,  // WHO 2021 24 saatlik ortalama sınır değeri
-		"NO2":   25.0,  // WHO 2021 24 saatlik ortalama sınır değeri
-		"SO2":   40.0,  // WHO 2021 24 saatlik ortalama sınır değeri
-		"O3":    100.0, // WHO 2021 8 saatlik ortalama sınır değeri
-	}
+// WHO 2021 guideline values in µg/m³.
+var thresholds = map[string]float64{
+	"PM2.5": 15.0,  // WHO 2021 24 saatlik ortalama sınır değeri
+	"PM10":  45.0,  // WHO 2021 24 saatlik ortalama sınır değeri
+	"NO2":   25.0,  // WHO 2021 24 saatlik ortalama sınır değeri
+	"SO2":   40.0,  // WHO 2021 24 saatlik ortalama sınır değeri
+	"O3":    100.0, // WHO 2021 8 saatlik ortalama sınır değeri
+}
 
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityLow      = "low"
+)
+
+// Threshold returns the WHO guideline of a parameter, or zero for
+// parameters without one.
+func Threshold(parameter string) float64 {
+	return thresholds[parameter]
+}
+
+func (a *Detector) CheckTreshold(parameter string, value float64) bool {
 	return value > thresholds[parameter]
 }
 
+// Severity grades an anomaly by how far the value exceeds the WHO guideline:
+// critical at twice the guideline or more, high above it and low for
+// statistical anomalies that stay below it.
+func Severity(parameter string, value float64) string {
+	threshold, ok := thresholds[parameter]
+	switch {
+	case !ok:
+		return SeverityLow
+	case value >= 2*threshold:
+		return SeverityCritical
+	case value > threshold:
+		return SeverityHigh
+	default:
+		return SeverityLow
+	}
+}
+
 func (a *Detector) isZScoreAnomalous(data models.AirQualityData, mean, count float64) bool {
 	stdDev := math.Sqrt(mean)
 	zScore := (data.Value - mean) / stdDev
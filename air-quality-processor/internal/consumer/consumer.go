@@ -4,24 +4,39 @@ import (
 	"api/internal/anomaly"
 	"api/internal/models"
 	"api/internal/notify"
+	"api/internal/queue"
 	"api/internal/repository"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
+	"time"
+
+	"contracts/messages"
 
 	"github.com/streadway/amqp"
 )
 
+const (
+	prefetchCount = 50
+	retryDelay    = time.Second
+)
+
 type Consumer struct {
-	QueueConn *amqp.Connection
-	Db        *sql.DB
+	QueueConn    *amqp.Connection
+	Db           *sql.DB
+	DedupeWindow time.Duration
+
+	notify               *notify.Notify
+	airQualityRepository *repository.AirQualityRepository
+	detector             *anomaly.Detector
+	messageRepository    *repository.MessageRepository
 }
 
-func NewConsumer(queueConn *amqp.Connection, db *sql.DB) *Consumer {
+func NewConsumer(queueConn *amqp.Connection, db *sql.DB, dedupeWindow time.Duration) *Consumer {
 	return &Consumer{
-		QueueConn: queueConn,
-		Db:        db,
+		QueueConn:    queueConn,
+		Db:           db,
+		DedupeWindow: dedupeWindow,
 	}
 }
 
@@ -32,26 +47,20 @@ func (c *Consumer) StartConsumer() {
 	}
 	defer ch.Close()
 
-	notify := notify.NewNotify(c.QueueConn)
-	airQualityRepository := repository.NewAirQualityRepository(c.Db)
-	AnomalyDetector := anomaly.NewAnomalyDetector(c.Db)
+	c.notify = notify.NewNotify(repository.NewOutboxRepository(c.Db))
+	c.airQualityRepository = repository.NewAirQualityRepository(c.Db)
+	c.detector = anomaly.NewAnomalyDetector(c.Db)
+	c.messageRepository = repository.NewMessageRepository(c.Db, c.DedupeWindow)
+	go c.messageRepository.StartCleanup(time.Minute)
 
-	q, err := ch.QueueDeclare(
-		"mesurements",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatalf("Failed to declare a queue: %s", err)
+	if err := ch.Qos(prefetchCount, 0, false); err != nil {
+		log.Fatalf("Failed to set QoS: %s", err)
 	}
 
 	msgs, err := ch.Consume(
-		q.Name,
+		queue.MeasurementsQueue,
 		"",
-		true,
+		false,
 		false,
 		false,
 		false,
@@ -61,27 +70,81 @@ func (c *Consumer) StartConsumer() {
 		log.Fatalf("Failed to register a consumer: %s", err)
 	}
 
+	retrier := queue.NewRetrier(c.QueueConn, queue.MeasurementsQueue)
 	forever := make(chan bool)
 
 	go func() {
 		for d := range msgs {
-			var data models.AirQualityData
-			if err := json.Unmarshal(d.Body, &data); err != nil {
+			envelope, reading, err := messages.DecodeReading(d.Body)
+			if err != nil {
 				log.Printf("Error decoding message: %s", err)
+				retrier.DeadLetter(d, err)
 				continue
 			}
+			data := models.AirQualityData{
+				MessageID: envelope.MessageID,
+				SensorID:  reading.SensorID,
+				Latitude:  reading.Latitude,
+				Longitude: reading.Longitude,
+				Parameter: reading.Parameter,
+				Value:     reading.Value,
+				Timestamp: reading.Timestamp,
+			}
 
 			fmt.Printf("Received a message: %+v\n", data)
 
-			if reason, ok := AnomalyDetector.IsAnomalous(data); ok {
-				fmt.Println("⚠️ Anomaly detected!", data)
-				notify.NotifyAnomaly(data, reason)
+			// The reading is acknowledged only after it has been committed,
+			// so a crash or database outage leads to redelivery rather than
+			// loss. While the database is down the reading is not at fault
+			// and is requeued; otherwise it counts as a failed attempt and
+			// is dead-lettered after the last one.
+			if err := c.process(data); err != nil {
+				log.Printf("Error processing message %s: %s", data.MessageID, err)
+				time.Sleep(retryDelay)
+				if pingErr := c.Db.Ping(); pingErr != nil {
+					d.Nack(false, true)
+					continue
+				}
+				retrier.Retry(d, err)
+				continue
 			}
-
-			airQualityRepository.SaveToDB(data)
+			d.Ack(false)
 		}
 	}()
 
 	log.Println(" [*] Waiting for messages. To exit press CTRL+C")
 	<-forever
 }
+
+// process stores the reading and, when it is anomalous, the alert for the
+// outbox in one transaction, so an alert exists exactly when its reading
+// was stored.
+func (c *Consumer) process(data models.AirQualityData) error {
+	tx, err := c.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	isNew, err := c.messageRepository.MarkProcessed(tx, data.MessageID)
+	if err != nil {
+		return err
+	}
+	if !isNew {
+		log.Printf("Dropping duplicate message %s", data.MessageID)
+		return nil
+	}
+
+	if err := c.airQualityRepository.SaveToDB(tx, data); err != nil {
+		return err
+	}
+
+	if reason, ok := c.detector.IsAnomalous(data); ok {
+		fmt.Println("⚠️ Anomaly detected!", data)
+		if err := c.notify.NotifyAnomaly(tx, data, reason); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -1,66 +1,59 @@
 package notify
 
 import (
+	"api/internal/anomaly"
 	"api/internal/models"
-	"encoding/json"
+	"api/internal/queue"
+	"api/internal/repository"
+	"database/sql"
+	"fmt"
 	"log"
 
-	"github.com/streadway/amqp"
+	"contracts/messages"
 )
 
 type Notify struct {
-	QueueConn *amqp.Connection
+	Outbox *repository.OutboxRepository
 }
 
-func NewNotify(queueConn *amqp.Connection) *Notify {
+func NewNotify(outbox *repository.OutboxRepository) *Notify {
 	return &Notify{
-		QueueConn: queueConn,
+		Outbox: outbox,
 	}
 }
 
-func (n *Notify) NotifyAnomaly(data models.AirQualityData, reason string) {
-	ch, err := n.QueueConn.Channel()
-	if err != nil {
-		log.Fatalf("Failed to open a channel: %s", err)
-	}
-	defer ch.Close()
-
-	q, err := ch.QueueDeclare(
-		"anomaly_alerts",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatalf("Failed to declare a queue: %s", err)
-	}
-
-	alert := models.AnomalyData{
+// NotifyAnomaly queues an anomaly alert in the outbox within tx, the
+// transaction that stores the reading. The outbox relay publishes it once
+// the transaction has committed.
+func (n *Notify) NotifyAnomaly(tx *sql.Tx, data models.AirQualityData, reason string) error {
+	alert := messages.Anomaly{
+		ReadingID:   data.MessageID,
+		SensorID:    data.SensorID,
 		Parameter:   data.Parameter,
 		Value:       data.Value,
 		Timestamp:   data.Timestamp,
 		Latitude:    data.Latitude,
 		Longitude:   data.Longitude,
 		Description: reason,
+		Severity:    anomaly.Severity(data.Parameter, data.Value),
+		Threshold:   anomaly.Threshold(data.Parameter),
 	}
 
-	body, _ := json.Marshal(alert)
-	err = ch.Publish(
-		"",
-		q.Name,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
-
+	// The ID is derived from the reading so that, should the reading be
+	// processed again, the repeated alert is recognised downstream.
+	messageID := messages.NewMessageID()
+	if data.MessageID != "" {
+		messageID = messages.AnomalyID(data.MessageID)
+	}
+	body, err := messages.EncodeAnomaly(messages.SourceProcessor, messageID, alert)
 	if err != nil {
-		log.Printf("Failed to publish anomaly alert: %s", err)
+		return fmt.Errorf("failed to encode anomaly alert: %w", err)
+	}
+
+	if err := n.Outbox.Add(tx, messageID, queue.AnomalyRoutingKey(alert.Severity, alert.Parameter), body); err != nil {
+		return err
 	}
 
-	log.Println("🚨 Anomaly alert sent:", string(body))
+	log.Println("🚨 Anomaly alert queued:", string(body))
+	return nil
 }
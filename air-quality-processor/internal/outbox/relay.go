@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"api/internal/queue"
+	"api/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"contracts/messages"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultInterval  = time.Second
+	defaultBatchSize = 100
+	defaultRetention = 24 * time.Hour
+	confirmTimeout   = 10 * time.Second
+)
+
+// Relay publishes messages committed to the outbox and marks them sent once
+// the broker confirmed them. A crash between publishing and marking results
+// in the message being published again, so delivery is at least once and
+// consumers drop duplicates by message ID.
+type Relay struct {
+	Db        *sql.DB
+	QueueConn *amqp.Connection
+	Outbox    *repository.OutboxRepository
+	Interval  time.Duration
+	BatchSize int
+	Retention time.Duration
+
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func NewRelay(db *sql.DB, queueConn *amqp.Connection) *Relay {
+	return &Relay{
+		Db:        db,
+		QueueConn: queueConn,
+		Outbox:    repository.NewOutboxRepository(db),
+		Interval:  defaultInterval,
+		BatchSize: defaultBatchSize,
+		Retention: defaultRetention,
+	}
+}
+
+func (r *Relay) Start() {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+	for range ticker.C {
+		// Keep draining while full batches come back so a backlog after an
+		// outage does not wait one interval per batch.
+		for {
+			sent, err := r.relayBatch()
+			if err != nil {
+				log.Printf("Outbox relay: %v", err)
+				break
+			}
+			if sent < r.BatchSize {
+				break
+			}
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			if err := r.Outbox.DeleteSent(r.Retention); err != nil {
+				log.Printf("Outbox relay: %v", err)
+			}
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+func (r *Relay) relayBatch() (int, error) {
+	tx, err := r.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	pending, err := r.Outbox.Pending(tx, r.BatchSize)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	var sent []int64
+	for _, message := range pending {
+		if err := r.publish(message); err != nil {
+			// Later messages stay pending too so alerts are not reordered.
+			if markErr := r.Outbox.MarkFailed(tx, message.ID, err); markErr != nil {
+				return 0, markErr
+			}
+			if err := r.Outbox.MarkSent(tx, sent); err != nil {
+				return 0, err
+			}
+			if err := tx.Commit(); err != nil {
+				return 0, err
+			}
+			return len(sent), fmt.Errorf("failed to publish %s: %w", message.MessageID, err)
+		}
+		sent = append(sent, message.ID)
+	}
+
+	if err := r.Outbox.MarkSent(tx, sent); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(sent), nil
+}
+
+// publish sends one message and waits for the broker's confirmation.
+func (r *Relay) publish(message repository.OutboxMessage) error {
+	if r.ch == nil {
+		ch, err := r.QueueConn.Channel()
+		if err != nil {
+			return err
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return err
+		}
+		r.ch = ch
+		r.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+
+	err := r.ch.Publish(
+		queue.Exchange,
+		message.RoutingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  messages.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    message.MessageID,
+			Body:         message.Payload,
+		},
+	)
+	if err == nil {
+		select {
+		case confirm, ok := <-r.confirms:
+			switch {
+			case !ok:
+				err = errors.New("channel closed before confirmation")
+			case !confirm.Ack:
+				err = errors.New("broker rejected message")
+			}
+		case <-time.After(confirmTimeout):
+			err = errors.New("timed out waiting for confirmation")
+		}
+	}
+	if err != nil {
+		// The channel's state is unknown; open a fresh one next time.
+		r.ch.Close()
+		r.ch = nil
+	}
+	return err
+}
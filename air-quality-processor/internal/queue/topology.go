@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// Readings arrive on the topic exchange as measurement.<parameter>.<region>
+// and anomaly alerts leave as anomaly.<severity>.<parameter>. Critical
+// alerts are routed to their own queue and everything below critical to
+// anomaly_alerts, so critical alerts are not stuck behind a backlog of
+// lower severity alerts.
+const (
+	Exchange             = "air_quality"
+	MeasurementsQueue    = "mesurements"
+	MeasurementsBinding  = "measurement.#"
+	AnomalyAlertsQueue   = "anomaly_alerts"
+	CriticalAlertsQueue  = "anomaly_alerts_critical"
+	CriticalAlertBinding = "anomaly.critical.#"
+
+	// legacyAnomalyBinding bound anomaly_alerts to every alert, critical
+	// ones included. Bindings outlive the services, so it is removed from
+	// brokers that still have it.
+	legacyAnomalyBinding = "anomaly.#"
+)
+
+// AnomalyBindings route the severities below critical to anomaly_alerts.
+var AnomalyBindings = []string{"anomaly.low.#", "anomaly.medium.#", "anomaly.high.#"}
+
+// DeclareTopology declares the exchange, the measurements queue this service
+// consumes and the alert queues it feeds, so alerts published before the
+// anomaly processor starts are not dropped, and the dead letter queue.
+func DeclareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, exchange := range []string{Exchange, DeadLetterExchange} {
+		if err := ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+		}
+	}
+
+	for name, patterns := range map[string][]string{
+		MeasurementsQueue:   {MeasurementsBinding},
+		AnomalyAlertsQueue:  AnomalyBindings,
+		CriticalAlertsQueue: {CriticalAlertBinding},
+	} {
+		if _, err := ch.QueueDeclare(name, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", name, err)
+		}
+		for _, pattern := range patterns {
+			if err := ch.QueueBind(name, pattern, Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %w", name, pattern, err)
+			}
+		}
+	}
+	if err := ch.QueueUnbind(AnomalyAlertsQueue, legacyAnomalyBinding, Exchange, nil); err != nil {
+		return fmt.Errorf("failed to unbind queue %s from %s: %w", AnomalyAlertsQueue, legacyAnomalyBinding, err)
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", DeadLetterQueue, err)
+	}
+	if err := ch.QueueBind(DeadLetterQueue, DeadLetterBinding, DeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %w", DeadLetterQueue, err)
+	}
+
+	return nil
+}
+
+func AnomalyRoutingKey(severity, parameter string) string {
+	return "anomaly." + severity + "." + RoutingWord(parameter)
+}
+
+// RoutingWord turns a parameter name into a single routing key word: lower
+// case without dots, so PM2.5 becomes pm25.
+func RoutingWord(parameter string) string {
+	return strings.ToLower(strings.NewReplacer(".", "", " ", "", "*", "", "#", "").Replace(parameter))
+}
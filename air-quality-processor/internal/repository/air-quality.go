@@ -4,7 +4,7 @@ import (
 	"api/internal/models"
 	"database/sql"
 	"fmt"
-	"log"
+	"time"
 )
 
 type AirQualityRepository struct {
@@ -17,16 +17,24 @@ func NewAirQualityRepository(db *sql.DB) *AirQualityRepository {
 	}
 }
 
-func (c *AirQualityRepository) SaveToDB(data models.AirQualityData) {
-	_, err := c.Db.Exec(`
-		INSERT INTO measurements (location, parameter, value)
+func (c *AirQualityRepository) SaveToDB(tx *sql.Tx, data models.AirQualityData) error {
+	_, err := tx.Exec(`
+		INSERT INTO measurements (location, parameter, value, time, sensor_id)
 		VALUES (ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
 		        $3,
-		        $4)
-	`, data.Longitude, data.Latitude, data.Parameter, data.Value)
+		        $4,
+		        COALESCE($5, now()),
+		        NULLIF($6, ''))
+	`, data.Longitude, data.Latitude, data.Parameter, data.Value, nullTime(data.Timestamp), data.SensorID)
 	if err != nil {
-		log.Printf("Failed to insert data: %v", err)
+		return fmt.Errorf("failed to insert data: %w", err)
 	}
+	return nil
+}
+
+// nullTime stores readings without a timestamp at insertion time.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
 
 func (c *AirQualityRepository) Get24HourDataForParameter(parameter string, latitude, longitude float64) ([]models.AirQualityData, error) {
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type MessageRepository struct {
+	Db     *sql.DB
+	Window time.Duration
+}
+
+func NewMessageRepository(db *sql.DB, window time.Duration) *MessageRepository {
+	return &MessageRepository{
+		Db:     db,
+		Window: window,
+	}
+}
+
+// MarkProcessed records the message ID in tx and reports whether it is new.
+// An ID seen within the window is a duplicate; one seen earlier than that is
+// treated as a fresh message and its timestamp is refreshed.
+func (r *MessageRepository) MarkProcessed(tx *sql.Tx, messageID string) (bool, error) {
+	if messageID == "" || r.Window == 0 {
+		return true, nil
+	}
+
+	var id string
+	err := tx.QueryRow(`
+		INSERT INTO processed_messages (message_id, processed_at)
+		VALUES ($1, now())
+		ON CONFLICT (message_id) DO UPDATE
+			SET processed_at = now()
+			WHERE processed_messages.processed_at < now() - make_interval(secs => $2)
+		RETURNING message_id
+	`, messageID, r.Window.Seconds()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record message id: %w", err)
+	}
+	return true, nil
+}
+
+// StartCleanup periodically deletes IDs that fell out of the window.
+func (r *MessageRepository) StartCleanup(interval time.Duration) {
+	if r.Window == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := r.Db.Exec(`
+			DELETE FROM processed_messages
+			WHERE processed_at < now() - make_interval(secs => $1)
+		`, r.Window.Seconds())
+		if err != nil {
+			log.Printf("Failed to clean up processed messages: %v", err)
+		}
+	}
+}
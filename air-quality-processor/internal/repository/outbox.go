@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OutboxMessage is a message waiting to be published to the topic exchange.
+type OutboxMessage struct {
+	ID         int64
+	MessageID  string
+	RoutingKey string
+	Payload    []byte
+}
+
+// OutboxRepository stores outgoing messages in the same transaction as the
+// data they describe, so a message exists exactly when its data was
+// committed. A relay publishes pending rows and marks them sent.
+type OutboxRepository struct {
+	Db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{
+		Db: db,
+	}
+}
+
+func (r *OutboxRepository) Add(tx *sql.Tx, messageID, routingKey string, payload []byte) error {
+	_, err := tx.Exec(`
+		INSERT INTO outbox (message_id, routing_key, payload)
+		VALUES ($1, $2, $3)
+	`, messageID, routingKey, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to add message %s to outbox: %w", messageID, err)
+	}
+	return nil
+}
+
+// Pending locks up to limit unsent messages in tx, critical alerts first and
+// otherwise oldest first. Rows locked by another relay instance are skipped.
+func (r *OutboxRepository) Pending(tx *sql.Tx, limit int) ([]OutboxMessage, error) {
+	rows, err := tx.Query(`
+		SELECT id, message_id, routing_key, payload
+		FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY routing_key LIKE 'anomaly.critical.%' DESC, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		if err := rows.Scan(&message.ID, &message.MessageID, &message.RoutingKey, &message.Payload); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (r *OutboxRepository) MarkSent(tx *sql.Tx, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE outbox
+		SET sent_at = now(), attempts = attempts + 1, last_error = NULL
+		WHERE id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox messages sent: %w", err)
+	}
+	return nil
+}
+
+func (r *OutboxRepository) MarkFailed(tx *sql.Tx, id int64, publishErr error) error {
+	_, err := tx.Exec(`
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2
+		WHERE id = $1
+	`, id, publishErr.Error())
+	if err != nil {
+		return fmt.Errorf("failed to record outbox failure: %w", err)
+	}
+	return nil
+}
+
+// DeleteSent removes messages published more than retention ago.
+func (r *OutboxRepository) DeleteSent(retention time.Duration) error {
+	_, err := r.Db.Exec(`
+		DELETE FROM outbox
+		WHERE sent_at < now() - make_interval(secs => $1)
+	`, retention.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to clean up outbox: %w", err)
+	}
+	return nil
+}
@@ -4,10 +4,17 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"api/internal/api"
 	"api/internal/consumer"
+	"api/internal/email"
+	"api/internal/escalation"
+	"api/internal/notify"
+	"api/internal/queue"
+	"api/internal/subscription"
+	"api/internal/webhook"
 	websocketserver "api/internal/websocket"
 	"api/pkg/db"
 
@@ -36,21 +43,77 @@ func main() {
 	}
 	defer conn.Close()
 
+	if err := queue.DeclareTopology(conn); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	Db := db.InitDB(dbURL)
 	defer Db.Close()
 
 	clients := make(map[*websocket.Conn]bool)
+	broadcaster := queue.NewBroadcaster(conn)
+	dispatcher := notify.NewDispatcher()
+	webhooks := webhook.NewNotifier(Db)
+	dispatcher.Register("webhook", webhooks)
+	dispatcher.AddNotifier(webhooks)
+
+	emailConfig, emailEnabled, err := email.ConfigFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if emailEnabled {
+		mailer := email.NewNotifier(emailConfig)
+		dispatcher.Register("email", mailer)
+		dispatcher.AddNotifier(mailer)
+		go mailer.Start()
+		fmt.Println("Email notifications enabled")
+	}
+	dispatcher.AddNotifier(subscription.NewMatcher(Db, dispatcher))
+
 	app := &app{
 		QueueConn: conn,
 		Db:        Db,
 		Clients:   clients,
 		WsServer:  websocketserver.NewWebsocketServer(Db, clients),
-		Api:       api.NewApi(Db),
+		Api:       api.NewApi(Db, broadcaster, dispatcher),
 	}
 
-	consumer := consumer.NewConsumer(app.QueueConn, app.Db, app.WsServer)
-	go consumer.StartConsumer()
-	fmt.Println("Consumer started")
+	// ANOMALY_PROCESSOR_ROLES selects what this instance does: "persist"
+	// stores alerts (instances compete, so each alert is stored once) and
+	// "broadcast" forwards stored alerts to this instance's WebSocket clients
+	// (every instance receives every alert). Both run by default.
+	persist, broadcast := roles(os.Getenv("ANOMALY_PROCESSOR_ROLES"))
+
+	if persist {
+		persister := consumer.NewConsumer(app.QueueConn, app.Db, broadcaster, dispatcher)
+		go persister.StartConsumer()
+		fmt.Println("Persisting consumer started")
+
+		deliverer, err := webhook.NewDelivererFromEnv(app.Db)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go deliverer.Start()
+		fmt.Println("Webhook deliverer started")
+
+		scheduler, err := escalation.NewSchedulerFromEnv(app.Db, dispatcher)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if scheduler != nil {
+			go scheduler.Start()
+			fmt.Println("Escalation scheduler started")
+		}
+	}
+	if broadcast {
+		broadcastConsumer := consumer.NewBroadcastConsumer(app.QueueConn, app.WsServer)
+		go broadcastConsumer.StartConsumer()
+		fmt.Println("Broadcast consumer started")
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -69,3 +132,21 @@ func main() {
 
 	wg.Wait()
 }
+
+func roles(value string) (persist, broadcast bool) {
+	if strings.TrimSpace(value) == "" {
+		return true, true
+	}
+	for _, role := range strings.Split(value, ",") {
+		switch strings.TrimSpace(role) {
+		case "persist":
+			persist = true
+		case "broadcast":
+			broadcast = true
+		default:
+			fmt.Println("Unknown anomaly processor role:", role)
+			os.Exit(1)
+		}
+	}
+	return persist, broadcast
+}
@@ -8,4 +8,9 @@ require github.com/streadway/amqp v1.1.0
 
 require github.com/lib/pq v1.10.9
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	contracts v0.0.0
+	github.com/gorilla/websocket v1.5.3 // indirect
+)
+
+replace contracts => ../contracts
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"api/internal/models"
+	"api/internal/repository"
+	"api/internal/workflow"
+	"api/pkg/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAnomalyPageSize = 50
+	maxAnomalyPageSize     = 500
+
+	// Readings within this distance (metres) of an anomaly count as taken at
+	// its location, and within this window around its time as its context.
+	anomalyContextRadius = 10
+	anomalyContextWindow = time.Hour
+)
+
+// AnomaliesHandler lists anomalies with any combination of filters.
+//
+//	GET /api/anomalies?parameter=PM2.5,NO2&severity=critical&start=...&limit=100
+//
+// parameter, description (the detector that raised it, also accepted as
+// detector), severity and status take comma separated values; state=open or
+// state=closed selects all open or closed statuses. The location is
+// narrowed by lat/lon/radius (km) or minLat/minLon/maxLat/maxLon, and
+// start/end are RFC3339. sort is time (default) or value, order is desc
+// (default) or asc. The response holds the page, the total number of matches
+// and nextCursor, which is passed back as cursor to fetch the next page with
+// the same filters.
+func (a *Api) AnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := repository.AnomalyFilter{
+		Parameters:   splitList(query.Get("parameter"), strings.ToUpper),
+		Descriptions: splitList(query.Get("description")+","+query.Get("detector"), strings.ToLower),
+		Severities:   splitList(query.Get("severity"), strings.ToLower),
+		Statuses:     splitList(query.Get("status"), strings.ToLower),
+		Assignee:     strings.TrimSpace(query.Get("assignee")),
+		SortBy:       repository.SortByTime,
+		Limit:        defaultAnomalyPageSize,
+		Cursor:       query.Get("cursor"),
+	}
+
+	switch query.Get("state") {
+	case "":
+	case "open":
+		filter.Statuses = append(filter.Statuses, workflow.OpenStatuses...)
+	case "closed":
+		filter.Statuses = append(filter.Statuses, workflow.ClosedStatuses...)
+	default:
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid state (use open or closed)")
+		return
+	}
+	for _, status := range filter.Statuses {
+		if !workflow.Valid(status) {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid status: "+status)
+			return
+		}
+	}
+
+	for name, target := range map[string]*time.Time{"start": &filter.Start, "end": &filter.End} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				utils.WriteJSONError(w, http.StatusBadRequest, "Invalid "+name+" time (use RFC3339: YYYY-MM-DDTHH:MM:SSZ)")
+				return
+			}
+			*target = parsed
+		}
+	}
+	if !filter.Start.IsZero() && !filter.End.IsZero() && filter.Start.After(filter.End) {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Start time must be before end time")
+		return
+	}
+
+	switch {
+	case query.Has("lat") || query.Has("lon") || query.Has("radius"):
+		values, err := parseFloats(query, "lat", "lon", "radius")
+		if err != nil || values[2] <= 0 {
+			utils.WriteJSONError(w, http.StatusBadRequest, "lat, lon and radius must all be numbers, radius greater than 0")
+			return
+		}
+		filter.Latitude, filter.Longitude, filter.Radius = &values[0], &values[1], values[2]
+	case query.Has("minLat") || query.Has("minLon") || query.Has("maxLat") || query.Has("maxLon"):
+		values, err := parseFloats(query, "minLat", "minLon", "maxLat", "maxLon")
+		if err != nil || values[0] >= values[2] || values[1] >= values[3] {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid bounding box: minLat, minLon, maxLat and maxLon are required and min must be less than max")
+			return
+		}
+		filter.MinLat, filter.MinLon, filter.MaxLat, filter.MaxLon = &values[0], &values[1], &values[2], &values[3]
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", repository.SortByTime, repository.SortByValue:
+		if sort != "" {
+			filter.SortBy = sort
+		}
+	default:
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid sort (use time or value)")
+		return
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid order (use asc or desc)")
+		return
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAnomalyPageSize {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid limit (use a number between 1 and "+strconv.Itoa(maxAnomalyPageSize)+")")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	repo := repository.NewAnomalyRepository(a.Db)
+	page, err := repo.ListAnomalies(filter)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve anomalies")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, page)
+}
+
+// AnomalyHandler returns one anomaly with the readings taken at its location
+// from an hour before until an hour after it was detected.
+//
+//	GET /api/anomalies/{id}
+func (a *Api) AnomalyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid anomaly ID")
+		return
+	}
+
+	anomaly, found, err := repository.NewAnomalyRepository(a.Db).GetAnomalyByID(id)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve anomaly")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "Anomaly not found")
+		return
+	}
+
+	anomalyTime, err := time.Parse(time.RFC3339, anomaly.Time)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve anomaly")
+		return
+	}
+
+	repo := repository.NewMeasurementRepository(a.Db)
+	readings, err := repo.GetReadingsAround(anomaly.Latitude, anomaly.Longitude, anomalyContextRadius,
+		anomalyTime.Add(-anomalyContextWindow), anomalyTime.Add(anomalyContextWindow))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve measurements")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, models.AnomalyDetail{Anomaly: anomaly, Measurements: readings})
+}
+
+type statusRequest struct {
+	Status   string  `json:"status"`
+	Assignee *string `json:"assignee"`
+	Notes    *string `json:"notes"`
+	Actor    string  `json:"actor"`
+}
+
+// AnomalyStatusHandler moves an anomaly through the acknowledgement
+// workflow and broadcasts the updated anomaly to WebSocket clients.
+//
+//	POST /api/anomalies/{id}/status
+//	{"status": "investigating", "assignee": "ayse", "notes": "...", "actor": "mehmet"}
+//
+// assignee and notes are optional; when given they replace the current
+// values, and an empty string clears them.
+func (a *Api) AnomalyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid anomaly ID")
+		return
+	}
+
+	var req statusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+	if !workflow.Valid(req.Status) {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid status (use open, acknowledged, investigating, false_positive or resolved)")
+		return
+	}
+
+	repo := repository.NewAnomalyRepository(a.Db)
+	anomaly, err := repo.UpdateAnomalyStatus(id, models.StatusChange{
+		Status:   req.Status,
+		Assignee: req.Assignee,
+		Notes:    req.Notes,
+		Actor:    strings.TrimSpace(req.Actor),
+	})
+	switch {
+	case errors.Is(err, repository.ErrAnomalyNotFound):
+		utils.WriteJSONError(w, http.StatusNotFound, "Anomaly not found")
+		return
+	case errors.Is(err, repository.ErrInvalidTransition):
+		utils.WriteJSONError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Println(err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to update anomaly")
+		return
+	}
+
+	// Clients replace the anomaly they already show by its id.
+	if a.Broadcaster != nil {
+		body, _ := json.Marshal(anomaly)
+		if err := a.Broadcaster.Publish(body); err != nil {
+			log.Println("Error publishing anomaly status broadcast:", err)
+		}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, anomaly)
+}
+
+// AnomalyHistoryHandler returns the recorded changes of an anomaly.
+//
+//	GET /api/anomalies/{id}/history
+func (a *Api) AnomalyHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid anomaly ID")
+		return
+	}
+
+	repo := repository.NewAnomalyRepository(a.Db)
+	_, found, err := repo.GetAnomalyByID(id)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve anomaly")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "Anomaly not found")
+		return
+	}
+
+	history, err := repo.GetAnomalyHistory(id)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve anomaly history")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, history)
+}
+
+// splitList splits a comma separated query value, dropping empty entries and
+// applying normalize to each one when given.
+func splitList(value string, normalize func(string) string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if normalize != nil {
+			item = normalize(item)
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"api/internal/notify"
+	"api/internal/queue"
 	"api/internal/repository"
 	"api/pkg/utils"
 	"database/sql"
@@ -11,12 +13,16 @@ import (
 )
 
 type Api struct {
-	Db *sql.DB
+	Db          *sql.DB
+	Broadcaster *queue.Broadcaster
+	Dispatcher  *notify.Dispatcher
 }
 
-func NewApi(Db *sql.DB) *Api {
+func NewApi(Db *sql.DB, broadcaster *queue.Broadcaster, dispatcher *notify.Dispatcher) *Api {
 	return &Api{
-		Db: Db,
+		Db:          Db,
+		Broadcaster: broadcaster,
+		Dispatcher:  dispatcher,
 	}
 }
 
@@ -36,9 +42,21 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func (a *Api) StartApi() {
+	http.HandleFunc("/api/anomalies", corsMiddleware(a.AnomaliesHandler))
+	http.HandleFunc("/api/anomalies/{id}", corsMiddleware(a.AnomalyHandler))
+	http.HandleFunc("/api/anomalies/{id}/status", corsMiddleware(a.AnomalyStatusHandler))
+	http.HandleFunc("/api/anomalies/{id}/history", corsMiddleware(a.AnomalyHistoryHandler))
 	http.HandleFunc("/api/anomalies/location", corsMiddleware(a.AnomaliesByLocationHandler))
 	http.HandleFunc("/api/anomalies/timerange", corsMiddleware(a.AnomaliesByTimeRangeHandler))
 	http.HandleFunc("/api/anomalies/density", corsMiddleware(a.AnomalyDensityHandler))
+	http.HandleFunc("/api/webhooks", corsMiddleware(a.WebhooksHandler))
+	http.HandleFunc("/api/webhooks/{id}", corsMiddleware(a.WebhookHandler))
+	http.HandleFunc("/api/webhooks/{id}/enable", corsMiddleware(a.WebhookEnableHandler))
+	http.HandleFunc("/api/webhooks/{id}/deliveries", corsMiddleware(a.WebhookDeliveriesHandler))
+	http.HandleFunc("/api/subscriptions", corsMiddleware(a.SubscriptionsHandler))
+	http.HandleFunc("/api/subscriptions/{id}", corsMiddleware(a.SubscriptionHandler))
+	http.HandleFunc("/api/measurements", corsMiddleware(a.MeasurementsHandler))
+	http.HandleFunc("/api/measurements/latest", corsMiddleware(a.LatestMeasurementsHandler))
 
 	log.Println("Starting API server on port 8081...")
 	err := http.ListenAndServe(":8081", nil)
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"api/internal/repository"
+	"api/pkg/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMeasurementRange  = 24 * time.Hour
+	defaultMeasurementBucket = time.Hour
+	maxMeasurementBuckets    = 10000
+	defaultLatestMaxAge      = 3 * time.Hour
+)
+
+// MeasurementsHandler returns a bucketed time series of the stored readings.
+//
+//	GET /api/measurements?parameter=PM2.5&start=...&end=...&bucket=15m
+//
+// start and end are RFC3339 (default: the last 24 hours) and bucket is a Go
+// duration (default 1h). The location is narrowed by sensorId, by
+// lat/lon/radius (km) or by minLat/minLon/maxLat/maxLon. Every bucket
+// carries avg, min, max, p95 and the number of readings.
+func (a *Api) MeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := repository.MeasurementQuery{
+		Parameter: strings.ToUpper(strings.TrimSpace(query.Get("parameter"))),
+		SensorID:  strings.TrimSpace(query.Get("sensorId")),
+		End:       time.Now().UTC(),
+		Bucket:    defaultMeasurementBucket,
+	}
+
+	if value := query.Get("end"); value != "" {
+		end, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid end time (use RFC3339: YYYY-MM-DDTHH:MM:SSZ)")
+			return
+		}
+		q.End = end
+	}
+	q.Start = q.End.Add(-defaultMeasurementRange)
+	if value := query.Get("start"); value != "" {
+		start, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid start time (use RFC3339: YYYY-MM-DDTHH:MM:SSZ)")
+			return
+		}
+		q.Start = start
+	}
+	if !q.Start.Before(q.End) {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Start time must be before end time")
+		return
+	}
+
+	if value := query.Get("bucket"); value != "" {
+		bucket, err := time.ParseDuration(value)
+		if err != nil || bucket < time.Second {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid bucket (use a duration such as 5m, 1h or 24h)")
+			return
+		}
+		q.Bucket = bucket
+	}
+	if q.End.Sub(q.Start)/q.Bucket > maxMeasurementBuckets {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Too many buckets: use a larger bucket or a shorter time range")
+		return
+	}
+
+	switch {
+	case query.Has("lat") || query.Has("lon") || query.Has("radius"):
+		values, err := parseFloats(query, "lat", "lon", "radius")
+		if err != nil || values[2] <= 0 {
+			utils.WriteJSONError(w, http.StatusBadRequest, "lat, lon and radius must all be numbers, radius greater than 0")
+			return
+		}
+		q.Latitude, q.Longitude, q.Radius = &values[0], &values[1], values[2]
+	case query.Has("minLat") || query.Has("minLon") || query.Has("maxLat") || query.Has("maxLon"):
+		values, err := parseFloats(query, "minLat", "minLon", "maxLat", "maxLon")
+		if err != nil || values[0] >= values[2] || values[1] >= values[3] {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid bounding box: minLat, minLon, maxLat and maxLon are required and min must be less than max")
+			return
+		}
+		q.MinLat, q.MinLon, q.MaxLat, q.MaxLon = &values[0], &values[1], &values[2], &values[3]
+	}
+
+	repo := repository.NewMeasurementRepository(a.Db)
+	series, err := repo.GetMeasurementSeries(q)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve measurements")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, series)
+}
+
+func parseFloats(query url.Values, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		value, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// LatestMeasurementsHandler returns the current air quality for every sensor
+// or location inside a bounding box, for drawing the map.
+//
+//	GET /api/measurements/latest?minLat=..&minLon=..&maxLat=..&maxLon=..
+//
+// Optional: parameter, and maxAge (Go duration, default 3h) to skip
+// locations that stopped reporting.
+func (a *Api) LatestMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	values, err := parseFloats(query, "minLat", "minLon", "maxLat", "maxLon")
+	if err != nil || values[0] >= values[2] || values[1] >= values[3] {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid bounding box: minLat, minLon, maxLat and maxLon are required and min must be less than max")
+		return
+	}
+
+	maxAge := defaultLatestMaxAge
+	if value := query.Get("maxAge"); value != "" {
+		if maxAge, err = time.ParseDuration(value); err != nil || maxAge <= 0 {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid maxAge (use a duration such as 30m or 3h)")
+			return
+		}
+	}
+
+	parameter := strings.ToUpper(strings.TrimSpace(query.Get("parameter")))
+
+	repo := repository.NewMeasurementRepository(a.Db)
+	snapshots, err := repo.GetLatestReadings(values[0], values[1], values[2], values[3], parameter, time.Now().Add(-maxAge))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve latest measurements")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, snapshots)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"api/internal/repository"
+	"api/pkg/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type subscriptionRequest struct {
+	UserID      string             `json:"userId"`
+	Name        string             `json:"name"`
+	Area        json.RawMessage    `json:"area"`
+	Center      *models.Point      `json:"center"`
+	Radius      float64            `json:"radius"`
+	Parameters  []string           `json:"parameters"`
+	MinSeverity string             `json:"minSeverity"`
+	QuietHours  *models.QuietHours `json:"quietHours"`
+	Channel     string             `json:"channel"`
+}
+
+// SubscriptionsHandler lists alert subscriptions (GET, optionally of one
+// user with ?userId=) or creates one (POST).
+//
+//	POST /api/subscriptions
+//	{"userId": "ayse", "name": "Kadıköy", "center": {"latitude": 40.99, "longitude": 29.03}, "radius": 2,
+//	 "parameters": ["PM2.5"], "minSeverity": "high",
+//	 "quietHours": {"start": "23:00", "end": "07:00", "timezone": "Europe/Istanbul"},
+//	 "channel": "email:ayse@example.com"}
+//
+// Instead of center and radius (km) the area can be given as a GeoJSON
+// Polygon or MultiPolygon in "area".
+func (a *Api) SubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	repo := repository.NewSubscriptionRepository(a.Db)
+
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions, err := repo.ListSubscriptions(strings.TrimSpace(r.URL.Query().Get("userId")))
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve subscriptions")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, subscriptions)
+
+	case http.MethodPost:
+		var req subscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		subscription, message := validateSubscription(req)
+		if message != "" {
+			utils.WriteJSONError(w, http.StatusBadRequest, message)
+			return
+		}
+
+		if err := a.Dispatcher.Validate(subscription.Channel); err != nil {
+			if errors.Is(err, notify.ErrInvalidRecipient) {
+				utils.WriteJSONError(w, http.StatusBadRequest,
+					"Invalid channel (use <channel>:<recipient>, e.g. email:ayse@example.com or webhook:3): "+err.Error())
+				return
+			}
+			log.Println(err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to validate channel")
+			return
+		}
+
+		created, err := repo.CreateSubscription(subscription)
+		switch {
+		case errors.Is(err, repository.ErrInvalidArea):
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid area (a valid GeoJSON Polygon or MultiPolygon is required)")
+		case errors.Is(err, repository.ErrInvalidTimezone):
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid quietHours timezone (use an IANA name such as Europe/Istanbul)")
+		case err != nil:
+			log.Println(err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to create subscription")
+		default:
+			utils.WriteJSONResponse(w, http.StatusCreated, created)
+		}
+
+	default:
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func validateSubscription(req subscriptionRequest) (models.Subscription, string) {
+	subscription := models.Subscription{
+		UserID:      strings.TrimSpace(req.UserID),
+		Name:        strings.TrimSpace(req.Name),
+		Parameters:  []string{},
+		MinSeverity: strings.ToLower(strings.TrimSpace(req.MinSeverity)),
+		Channel:     strings.TrimSpace(req.Channel),
+	}
+
+	if subscription.UserID == "" {
+		return subscription, "Missing userId"
+	}
+
+	hasArea := len(req.Area) > 0 && string(req.Area) != "null"
+	switch {
+	case hasArea && req.Center != nil:
+		return subscription, "Use either area or center and radius, not both"
+	case hasArea:
+		var geometry struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(req.Area, &geometry); err != nil || (geometry.Type != "Polygon" && geometry.Type != "MultiPolygon") {
+			return subscription, "Invalid area (a GeoJSON Polygon or MultiPolygon is required)"
+		}
+		subscription.Area = req.Area
+	case req.Center != nil:
+		if req.Center.Latitude < -90 || req.Center.Latitude > 90 || req.Center.Longitude < -180 || req.Center.Longitude > 180 {
+			return subscription, "Invalid center coordinates"
+		}
+		if req.Radius <= 0 {
+			return subscription, "radius (km) must be greater than 0"
+		}
+		subscription.Center, subscription.Radius = req.Center, req.Radius
+	default:
+		return subscription, "Missing area or center and radius"
+	}
+
+	for _, parameter := range req.Parameters {
+		if parameter = strings.ToUpper(strings.TrimSpace(parameter)); parameter != "" {
+			subscription.Parameters = append(subscription.Parameters, parameter)
+		}
+	}
+	if subscription.MinSeverity == "" {
+		subscription.MinSeverity = "low"
+	}
+	if !severities[subscription.MinSeverity] {
+		return subscription, "Invalid minSeverity (use critical, high or low)"
+	}
+
+	if req.QuietHours != nil {
+		quietHours := *req.QuietHours
+		start, errStart := time.Parse("15:04", quietHours.Start)
+		end, errEnd := time.Parse("15:04", quietHours.End)
+		if errStart != nil || errEnd != nil || start.Equal(end) {
+			return subscription, "Invalid quietHours (use different start and end times as HH:MM)"
+		}
+		quietHours.Timezone = strings.TrimSpace(quietHours.Timezone)
+		if quietHours.Timezone == "" {
+			quietHours.Timezone = "UTC"
+		}
+		subscription.QuietHours = &quietHours
+	}
+
+	return subscription, ""
+}
+
+// SubscriptionHandler returns (GET) or deletes (DELETE) one subscription.
+//
+//	GET    /api/subscriptions/{id}
+//	DELETE /api/subscriptions/{id}
+func (a *Api) SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid subscription ID")
+		return
+	}
+	repo := repository.NewSubscriptionRepository(a.Db)
+
+	switch r.Method {
+	case http.MethodGet:
+		subscription, found, err := repo.GetSubscription(id)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve subscription")
+			return
+		}
+		if !found {
+			utils.WriteJSONError(w, http.StatusNotFound, "Subscription not found")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, subscription)
+
+	case http.MethodDelete:
+		deleted, err := repo.DeleteSubscription(id)
+		if err != nil {
+			log.Println(err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to delete subscription")
+			return
+		}
+		if !deleted {
+			utils.WriteJSONError(w, http.StatusNotFound, "Subscription not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"api/internal/models"
+	"api/internal/repository"
+	"api/internal/webhook"
+	"api/pkg/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 500
+)
+
+var severities = map[string]bool{"critical": true, "high": true, "low": true}
+
+type webhookRequest struct {
+	URL         string              `json:"url"`
+	Secret      string              `json:"secret"`
+	Format      string              `json:"format"`
+	Description string              `json:"description"`
+	Parameters  []string            `json:"parameters"`
+	Severities  []string            `json:"severities"`
+	Region      *models.BoundingBox `json:"region"`
+}
+
+// WebhooksHandler lists the registered webhooks (GET) or registers a new
+// one (POST).
+//
+//	POST /api/webhooks
+//	{"url": "https://example.com/hook", "parameters": ["PM2.5"], "severities": ["critical"],
+//	 "region": {"minLat": 40.8, "minLon": 28.5, "maxLat": 41.3, "maxLon": 29.5}}
+//
+// Format is json (default), slack or teams; for Slack and Microsoft Teams
+// the url is the channel's incoming webhook URL. When no secret is given
+// one is generated. The secret is only returned in this response.
+func (a *Api) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	repo := repository.NewWebhookRepository(a.Db)
+
+	switch r.Method {
+	case http.MethodGet:
+		webhooks, err := repo.ListWebhooks()
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve webhooks")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, webhooks)
+
+	case http.MethodPost:
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		webhook, message := req.validate()
+		if message != "" {
+			utils.WriteJSONError(w, http.StatusBadRequest, message)
+			return
+		}
+		if webhook.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to generate secret")
+				return
+			}
+			webhook.Secret = hex.EncodeToString(secret)
+		}
+
+		created, err := repo.CreateWebhook(webhook)
+		if err != nil {
+			log.Println(err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusCreated, created)
+
+	default:
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (req webhookRequest) validate() (models.Webhook, string) {
+	webhook := models.Webhook{
+		URL:         strings.TrimSpace(req.URL),
+		Secret:      req.Secret,
+		Description: strings.TrimSpace(req.Description),
+		Parameters:  []string{},
+		Severities:  []string{},
+		Region:      req.Region,
+	}
+
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return webhook, "Invalid url (an absolute http or https URL is required)"
+	}
+	format, ok := webhookFormat(req.Format)
+	if !ok {
+		return webhook, "Invalid format (use json, slack or teams)"
+	}
+	webhook.Format = format
+	for _, parameter := range req.Parameters {
+		if parameter = strings.ToUpper(strings.TrimSpace(parameter)); parameter != "" {
+			webhook.Parameters = append(webhook.Parameters, parameter)
+		}
+	}
+	for _, severity := range req.Severities {
+		severity = strings.ToLower(strings.TrimSpace(severity))
+		if !severities[severity] {
+			return webhook, "Invalid severity (use critical, high or low)"
+		}
+		webhook.Severities = append(webhook.Severities, severity)
+	}
+	if webhook.Region != nil && !webhook.Region.Valid() {
+		return webhook, "Invalid region: minLat, minLon, maxLat and maxLon are required and min must be less than max"
+	}
+	return webhook, ""
+}
+
+// webhookFormat normalizes a requested format, defaulting to json.
+func webhookFormat(value string) (string, bool) {
+	format := strings.ToLower(strings.TrimSpace(value))
+	if format == "" {
+		return webhook.FormatJSON, true
+	}
+	return format, slices.Contains(webhook.Formats, format)
+}
+
+// WebhookHandler returns (GET) or deletes (DELETE) one webhook.
+//
+//	GET    /api/webhooks/{id}
+//	DELETE /api/webhooks/{id}
+func (a *Api) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	repo := repository.NewWebhookRepository(a.Db)
+
+	switch r.Method {
+	case http.MethodGet:
+		webhook, found, err := repo.GetWebhook(id)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve webhook")
+			return
+		}
+		if !found {
+			utils.WriteJSONError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, webhook)
+
+	case http.MethodDelete:
+		deleted, err := repo.DeleteWebhook(id)
+		if err != nil {
+			log.Println(err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to delete webhook")
+			return
+		}
+		if !deleted {
+			utils.WriteJSONError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// WebhookEnableHandler re-enables a webhook that was disabled after
+// repeated failures.
+//
+//	POST /api/webhooks/{id}/enable
+func (a *Api) WebhookEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	webhook, found, err := repository.NewWebhookRepository(a.Db).EnableWebhook(id)
+	if err != nil {
+		log.Println(err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to enable webhook")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, webhook)
+}
+
+// WebhookDeliveriesHandler returns a webhook's delivery log, newest first.
+//
+//	GET /api/webhooks/{id}/deliveries?limit=50
+func (a *Api) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	limit := defaultDeliveryPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxDeliveryPageSize {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid limit (use a number between 1 and "+strconv.Itoa(maxDeliveryPageSize)+")")
+			return
+		}
+	}
+
+	repo := repository.NewWebhookRepository(a.Db)
+	_, found, err := repo.GetWebhook(id)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve webhook")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	deliveries, err := repo.ListDeliveries(id, limit)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to retrieve deliveries")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,54 @@
+package aqi
+
+// Categories of the European Air Quality Index (EEA), from best to worst.
+const (
+	Good          = "good"
+	Fair          = "fair"
+	Moderate      = "moderate"
+	Poor          = "poor"
+	VeryPoor      = "very_poor"
+	ExtremelyPoor = "extremely_poor"
+)
+
+var categories = []string{Good, Fair, Moderate, Poor, VeryPoor, ExtremelyPoor}
+
+// Upper bounds (µg/m³) of each category but the last, per pollutant.
+var bands = map[string][5]float64{
+	"PM2.5": {5, 15, 50, 90, 140},
+	"PM10":  {15, 45, 120, 195, 270},
+	"NO2":   {10, 25, 60, 100, 150},
+	"O3":    {60, 100, 120, 160, 180},
+	"SO2":   {20, 40, 125, 190, 275},
+}
+
+// Category returns the index category of a concentration in µg/m³, or ""
+// for parameters the index does not cover.
+func Category(parameter string, value float64) string {
+	limits, ok := bands[parameter]
+	if !ok {
+		return ""
+	}
+	for i, limit := range limits {
+		if value <= limit {
+			return categories[i]
+		}
+	}
+	return ExtremelyPoor
+}
+
+// Worse returns the worse of two categories; "" counts as unknown.
+func Worse(a, b string) string {
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+func rank(category string) int {
+	for i, c := range categories {
+		if c == category {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package consumer
+
+import (
+	"api/internal/queue"
+	websocketserver "api/internal/websocket"
+	"log"
+
+	"github.com/streadway/amqp"
+)
+
+// BroadcastConsumer is the broadcast role: every instance declares its own
+// exclusive, auto-deleted queue on the broadcast exchange and forwards each
+// message to the WebSocket clients connected to it.
+type BroadcastConsumer struct {
+	QueueConn *amqp.Connection
+	WsServer  *websocketserver.WebsocketServer
+}
+
+func NewBroadcastConsumer(queueConn *amqp.Connection, WsServer *websocketserver.WebsocketServer) *BroadcastConsumer {
+	return &BroadcastConsumer{
+		QueueConn: queueConn,
+		WsServer:  WsServer,
+	}
+}
+
+func (c *BroadcastConsumer) StartConsumer() {
+	ch, err := c.QueueConn.Channel()
+	if err != nil {
+		log.Fatal("Channel error:", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclare(
+		"",
+		false,
+		true,
+		true,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatal("Queue declare error:", err)
+	}
+
+	if err := ch.QueueBind(q.Name, "", queue.BroadcastExchange, false, nil); err != nil {
+		log.Fatal("Queue bind error:", err)
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,
+		"",
+		true,
+		true,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Fatal("Consume error:", err)
+	}
+
+	for msg := range msgs {
+		c.WsServer.BroadcastToClients(msg.Body)
+	}
+	log.Fatal("Broadcast queue closed")
+}
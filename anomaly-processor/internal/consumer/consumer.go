@@ -1,51 +1,76 @@
 package consumer
 
 import (
+	"api/internal/models"
+	"api/internal/notify"
+	"api/internal/queue"
 	"api/internal/repository"
-	websocketserver "api/internal/websocket"
+	"api/internal/workflow"
 	"database/sql"
+	"encoding/json"
 	"log"
+	"time"
+
+	"contracts/messages"
 
 	"github.com/streadway/amqp"
 )
 
+const (
+	prefetchCount = 50
+	retryDelay    = time.Second
+)
+
+// Consumer is the persisting role: instances compete on anomaly_alerts so
+// each anomaly is stored once, then hand it to every instance through the
+// broadcast exchange.
 type Consumer struct {
-	QueueConn *amqp.Connection
-	Db        *sql.DB
-	WsServer  *websocketserver.WebsocketServer
+	QueueConn   *amqp.Connection
+	Db          *sql.DB
+	Broadcaster *queue.Broadcaster
+	Dispatcher  *notify.Dispatcher
+
+	anomalyRepository *repository.AnomalyRepository
 }
 
-func NewConsumer(queueConn *amqp.Connection, db *sql.DB, WsServer *websocketserver.WebsocketServer) *Consumer {
+func NewConsumer(queueConn *amqp.Connection, db *sql.DB, broadcaster *queue.Broadcaster, dispatcher *notify.Dispatcher) *Consumer {
 	return &Consumer{
-		QueueConn: queueConn,
-		Db:        db,
-		WsServer:  WsServer,
+		QueueConn:   queueConn,
+		Db:          db,
+		Broadcaster: broadcaster,
+		Dispatcher:  dispatcher,
 	}
 }
 
+// StartConsumer consumes the critical and the regular alert queue on
+// separate channels, so critical alerts are stored and broadcast right away
+// even while a backlog of other alerts is being worked off.
 func (c *Consumer) StartConsumer() {
+	c.anomalyRepository = repository.NewAnomalyRepository(c.Db)
+
+	go c.consume(queue.CriticalAlertsQueue)
+	go c.consume(queue.AnomalyAlertsQueue)
+
+	log.Println(" [*] Waiting for messages. To exit press CTRL+C")
+	forever := make(chan bool)
+	<-forever
+}
+
+func (c *Consumer) consume(queueName string) {
 	ch, err := c.QueueConn.Channel()
 	if err != nil {
 		log.Fatal("Channel error:", err)
 	}
 	defer ch.Close()
 
-	q, err := ch.QueueDeclare(
-		"anomaly_alerts",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatal("Queue declare error:", err)
+	if err := ch.Qos(prefetchCount, 0, false); err != nil {
+		log.Fatal("QoS error:", err)
 	}
 
 	msgs, err := ch.Consume(
-		q.Name,
+		queueName,
 		"",
-		true,
+		false,
 		false,
 		false,
 		false,
@@ -54,19 +79,125 @@ func (c *Consumer) StartConsumer() {
 	if err != nil {
 		log.Fatal("Consume error:", err)
 	}
-	anomalyRepository := repository.NewAnomalyRepository(c.Db)
 
-	forever := make(chan bool)
+	retrier := queue.NewRetrier(c.QueueConn, queueName)
+	for msg := range msgs {
+		c.handle(msg, retrier)
+	}
+	log.Fatalf("Consumer for %s stopped", queueName)
+}
 
-	go func() {
-		for msg := range msgs {
-			log.Printf("Received message: %s", msg.Body)
-			anomalyRepository.SaveAnomalyToDB(msg.Body)
-			c.WsServer.BroadcastToClients(msg.Body)
+// handle stores and notifies one alert. An alert that fails while the
+// database is reachable is retried at the back of the queue and
+// dead-lettered after the last attempt, so it cannot block the queue.
+func (c *Consumer) handle(msg amqp.Delivery, retrier *queue.Retrier) {
+	log.Printf("Received message: %s", msg.Body)
+	envelope, anomaly, err := messages.DecodeAnomaly(msg.Body)
+	if err == nil {
+		if anomaly.Timestamp.IsZero() {
+			anomaly.Timestamp = time.Now().UTC()
 		}
-	}()
+		err = anomaly.Validate()
+	}
+	if err != nil {
+		// Malformed messages will never succeed; set them aside.
+		log.Println("Rejecting anomaly message:", err)
+		retrier.DeadLetter(msg, err)
+		return
+	}
 
-	log.Println(" [*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+	anomalyID := envelope.MessageID
+	if anomalyID == "" {
+		anomalyID = anomaly.ContentID()
+	}
 
+	id, inserted, err := c.anomalyRepository.SaveAnomalyToDB(anomalyID, anomaly)
+	if err != nil {
+		log.Println(err)
+		c.retry(msg, retrier, err)
+		return
+	}
+
+	stored := models.Anomaly{
+		ID:          id,
+		Parameter:   anomaly.Parameter,
+		Value:       anomaly.Value,
+		Time:        anomaly.Timestamp.UTC().Format(time.RFC3339),
+		Longitude:   anomaly.Longitude,
+		Latitude:    anomaly.Latitude,
+		Description: anomaly.Description,
+		Severity:    anomaly.Severity,
+		Threshold:   anomaly.Threshold,
+		Status:      workflow.StatusOpen,
+	}
+
+	if !inserted {
+		log.Printf("Anomaly %s already stored", anomalyID)
+	}
+	if err := c.broadcast(stored); err != nil {
+		log.Println("Error publishing anomaly broadcast:", err)
+		c.retry(msg, retrier, err)
+		return
+	}
+
+	if err := c.notify(stored); err != nil {
+		log.Println("Error notifying anomaly:", err)
+		c.retry(msg, retrier, err)
+		return
+	}
+
+	// Acknowledge only after the anomaly has been written, so a crash or
+	// database outage leads to redelivery.
+	msg.Ack(false)
+}
+
+// retry requeues an alert while the database is down, since the alert is
+// not at fault, and otherwise counts a failed attempt.
+func (c *Consumer) retry(msg amqp.Delivery, retrier *queue.Retrier, cause error) {
+	time.Sleep(retryDelay)
+	if err := c.Db.Ping(); err != nil {
+		msg.Nack(false, true)
+		return
+	}
+	retrier.Retry(msg, cause)
+}
+
+// broadcast sends a stored anomaly to the WebSocket clients of every
+// instance unless that has already happened. A failed publish is undone so
+// the redelivered alert is broadcast again.
+func (c *Consumer) broadcast(anomaly models.Anomaly) error {
+	claimed, err := c.anomalyRepository.ClaimBroadcast(anomaly.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	// WebSocket clients get the same shape as the REST API.
+	body, _ := json.Marshal(anomaly)
+	if err := c.Broadcaster.Publish(body); err != nil {
+		if releaseErr := c.anomalyRepository.ReleaseBroadcast(anomaly.ID); releaseErr != nil {
+			log.Println(releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// notify hands a stored anomaly to the notifiers unless that has already
+// happened, e.g. for the copy of a critical alert from the other queue.
+func (c *Consumer) notify(anomaly models.Anomaly) error {
+	if c.Dispatcher == nil {
+		return nil
+	}
+
+	claimed, err := c.anomalyRepository.ClaimNotification(anomaly.ID)
+	if err != nil || !claimed {
+		return err
+	}
+	if err := c.Dispatcher.NotifyAnomaly(anomaly); err != nil {
+		if releaseErr := c.anomalyRepository.ReleaseNotification(anomaly.ID); releaseErr != nil {
+			log.Println(releaseErr)
+		}
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,265 @@
+package email
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPort         = "25"
+	defaultFrom         = "Air Quality Monitoring <air-quality@localhost>"
+	defaultSeverities   = "critical"
+	defaultDigestWindow = time.Minute
+	defaultDashboardURL = "http://localhost:3000"
+
+	flushInterval = 5 * time.Second
+	maxPending    = 1000
+	seenRetention = 24 * time.Hour
+)
+
+type Config struct {
+	Host         string
+	Port         string
+	Username     string
+	Password     string
+	From         string
+	Recipients   []string
+	Severities   map[string]bool
+	DigestWindow time.Duration
+	DashboardURL string
+}
+
+// ConfigFromEnv reads SMTP_HOST, SMTP_PORT (default 25), SMTP_USERNAME,
+// SMTP_PASSWORD, SMTP_FROM, EMAIL_RECIPIENTS (comma separated),
+// EMAIL_SEVERITIES (default critical), EMAIL_DIGEST_WINDOW (default 1m) and
+// DASHBOARD_URL. The second result is false when SMTP_HOST is not set.
+func ConfigFromEnv() (Config, bool, error) {
+	cfg := Config{
+		Host:         strings.TrimSpace(os.Getenv("SMTP_HOST")),
+		Port:         envOr("SMTP_PORT", defaultPort),
+		Username:     os.Getenv("SMTP_USERNAME"),
+		Password:     os.Getenv("SMTP_PASSWORD"),
+		From:         envOr("SMTP_FROM", defaultFrom),
+		Severities:   make(map[string]bool),
+		DigestWindow: defaultDigestWindow,
+		DashboardURL: strings.TrimRight(envOr("DASHBOARD_URL", defaultDashboardURL), "/"),
+	}
+	if cfg.Host == "" {
+		return cfg, false, nil
+	}
+
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return cfg, false, fmt.Errorf("invalid SMTP_FROM %q: %w", cfg.From, err)
+	}
+	for _, recipient := range strings.Split(os.Getenv("EMAIL_RECIPIENTS"), ",") {
+		if recipient = strings.TrimSpace(recipient); recipient == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return cfg, false, fmt.Errorf("invalid EMAIL_RECIPIENTS entry %q: %w", recipient, err)
+		}
+		cfg.Recipients = append(cfg.Recipients, recipient)
+	}
+	for _, severity := range strings.Split(envOr("EMAIL_SEVERITIES", defaultSeverities), ",") {
+		if severity = strings.ToLower(strings.TrimSpace(severity)); severity != "" {
+			cfg.Severities[severity] = true
+		}
+	}
+	if value := os.Getenv("EMAIL_DIGEST_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			return cfg, false, fmt.Errorf("invalid EMAIL_DIGEST_WINDOW %q", value)
+		}
+		cfg.DigestWindow = window
+	}
+
+	return cfg, true, nil
+}
+
+func envOr(name, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Notifier emails anomalies of the configured severities to the configured
+// recipients. The first alert is sent right away; alerts arriving within
+// DigestWindow of the last email are collected and sent together as one
+// digest when the window has passed, so a burst of alerts does not flood
+// the inbox. Collected alerts are kept in memory only.
+//
+// The consumer calls every notifier again when one of them fails, so
+// anomalies already emailed or waiting for a digest are remembered by ID
+// for a day and not added twice.
+//
+// It is also a notify.Channel, so escalation policies can email a single
+// address as "email:<address>"; those are sent right away.
+type Notifier struct {
+	Config Config
+
+	mu           sync.Mutex
+	lastSent     time.Time
+	pending      []models.Anomaly
+	pendingSince time.Time
+	seen         map[int64]time.Time
+	lastPruned   time.Time
+}
+
+func NewNotifier(config Config) *Notifier {
+	return &Notifier{Config: config, seen: make(map[int64]time.Time)}
+}
+
+func (n *Notifier) NotifyAnomaly(anomaly models.Anomaly) error {
+	if len(n.Config.Recipients) == 0 || !n.Config.Severities[anomaly.Severity] {
+		return nil
+	}
+
+	n.mu.Lock()
+	if _, ok := n.seen[anomaly.ID]; ok {
+		n.mu.Unlock()
+		return nil
+	}
+	n.seen[anomaly.ID] = time.Now()
+
+	if len(n.pending) == 0 && time.Since(n.lastSent) >= n.Config.DigestWindow {
+		n.lastSent = time.Now()
+		n.mu.Unlock()
+
+		msg, err := alertMessage(anomaly, 0, n.Config.DashboardURL)
+		if err == nil {
+			err = n.send(n.Config.Recipients, msg)
+		}
+		if err != nil {
+			// Not sent, so the retry of the anomaly may send it.
+			n.mu.Lock()
+			delete(n.seen, anomaly.ID)
+			n.mu.Unlock()
+		}
+		return err
+	}
+
+	if len(n.pending) == 0 {
+		n.pendingSince = time.Now()
+	}
+	n.add(anomaly)
+	n.mu.Unlock()
+	return nil
+}
+
+// add queues an anomaly for the next digest, dropping the oldest one when
+// too many are waiting. n.mu must be held.
+func (n *Notifier) add(anomalies ...models.Anomaly) {
+	n.pending = append(n.pending, anomalies...)
+	if over := len(n.pending) - maxPending; over > 0 {
+		log.Printf("Email digest full, dropping %d anomalies", over)
+		n.pending = n.pending[over:]
+	}
+}
+
+func (n *Notifier) Validate(recipient string) error {
+	if _, err := mail.ParseAddress(recipient); err != nil {
+		return fmt.Errorf("%w: email address %q", notify.ErrInvalidRecipient, recipient)
+	}
+	return nil
+}
+
+func (n *Notifier) Send(recipient string, notification notify.Notification) error {
+	if err := n.Validate(recipient); err != nil {
+		return err
+	}
+	msg, err := alertMessage(notification.Anomaly, notification.Level, n.Config.DashboardURL)
+	if err != nil {
+		return err
+	}
+	return n.send([]string{recipient}, msg)
+}
+
+// Start sends the collected alerts once the digest window has passed.
+func (n *Notifier) Start() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n.flush()
+	}
+}
+
+func (n *Notifier) flush() {
+	n.mu.Lock()
+	if time.Since(n.lastPruned) > time.Hour {
+		for id, at := range n.seen {
+			if time.Since(at) > seenRetention {
+				delete(n.seen, id)
+			}
+		}
+		n.lastPruned = time.Now()
+	}
+	if len(n.pending) == 0 || time.Since(n.lastSent) < n.Config.DigestWindow {
+		n.mu.Unlock()
+		return
+	}
+	anomalies, since := n.pending, n.pendingSince
+	n.pending = nil
+	n.lastSent = time.Now()
+	n.mu.Unlock()
+
+	var msg message
+	var err error
+	if len(anomalies) == 1 {
+		msg, err = alertMessage(anomalies[0], 0, n.Config.DashboardURL)
+	} else {
+		msg, err = digestMessage(anomalies, since, n.Config.DashboardURL)
+	}
+	if err == nil {
+		err = n.send(n.Config.Recipients, msg)
+	}
+	if err != nil {
+		log.Printf("Error sending email digest of %d anomalies: %v", len(anomalies), err)
+
+		// Try again with the next digest.
+		n.mu.Lock()
+		n.pendingSince = since
+		n.pending = append(anomalies, n.pending...)
+		n.add()
+		n.mu.Unlock()
+	}
+}
+
+func (n *Notifier) send(to []string, msg message) error {
+	data, err := msg.build(n.Config.From, to)
+	if err != nil {
+		return err
+	}
+
+	from, _ := mail.ParseAddress(n.Config.From)
+	recipients := make([]string, 0, len(to))
+	for _, recipient := range to {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, address.Address)
+	}
+
+	var auth smtp.Auth
+	if n.Config.Username != "" {
+		auth = smtp.PlainAuth("", n.Config.Username, n.Config.Password, n.Config.Host)
+	}
+
+	addr := net.JoinHostPort(n.Config.Host, n.Config.Port)
+	if err := smtp.SendMail(addr, auth, from.Address, recipients, data); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", strings.Join(recipients, ", "), err)
+	}
+	log.Printf("Sent email %q to %s", msg.Subject, strings.Join(recipients, ", "))
+	return nil
+}
@@ -0,0 +1,279 @@
+package email
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentMail is one message accepted by smtpSink.
+type sentMail struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// smtpSink is a minimal SMTP server on a local port that keeps every message
+// it accepts. Recipients listed in reject are refused with 550.
+type smtpSink struct {
+	t        *testing.T
+	listener net.Listener
+	reject   map[string]bool
+
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start SMTP sink: %v", err)
+	}
+	sink := &smtpSink{t: t, listener: listener, reject: make(map[string]bool)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) config() Config {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return Config{
+		Host:         host,
+		Port:         port,
+		From:         "Air Quality <alerts@example.com>",
+		Recipients:   []string{"oncall@example.com", "Ops <ops@example.com>"},
+		Severities:   map[string]bool{"critical": true},
+		DigestWindow: time.Hour,
+		DashboardURL: "http://dashboard.example.com",
+	}
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var current sentMail
+	reply("220 localhost ESMTP sink")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			current = sentMail{From: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient := strings.Trim(line[len("RCPT TO:"):], "<> ")
+			if s.reject[recipient] {
+				reply("550 No such user")
+				continue
+			}
+			current.To = append(current.To, recipient)
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			msg, err := mail.ReadMessage(&data)
+			if err != nil {
+				s.t.Errorf("sink received a malformed message: %v", err)
+				reply("554 Malformed")
+				continue
+			}
+			body, _ := io.ReadAll(msg.Body)
+			current.Subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			current.Body = string(body)
+			s.mu.Lock()
+			s.sent = append(s.sent, current)
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "RSET", command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) messages() []sentMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sentMail(nil), s.sent...)
+}
+
+func criticalAnomaly(id int64, value float64) models.Anomaly {
+	return models.Anomaly{
+		ID:          id,
+		Parameter:   "PM2.5",
+		Value:       value,
+		Time:        "2024-01-15T10:30:00Z",
+		Latitude:    41.01,
+		Longitude:   28.97,
+		Description: "Threshold exceeded",
+		Severity:    "critical",
+		Threshold:   75,
+	}
+}
+
+func TestNotifyAnomalySendsFirstAlertRightAway(t *testing.T) {
+	sink := newSMTPSink(t)
+	notifier := NewNotifier(sink.config())
+
+	if err := notifier.NotifyAnomaly(criticalAnomaly(1, 187.5)); err != nil {
+		t.Fatalf("NotifyAnomaly returned error: %v", err)
+	}
+
+	sent := sink.messages()
+	if len(sent) != 1 {
+		t.Fatalf("sink received %d messages, want 1", len(sent))
+	}
+	msg := sent[0]
+	if msg.From != "alerts@example.com" {
+		t.Errorf("envelope sender = %q, want alerts@example.com", msg.From)
+	}
+	if want := []string{"oncall@example.com", "ops@example.com"}; strings.Join(msg.To, ",") != strings.Join(want, ",") {
+		t.Errorf("envelope recipients = %v, want %v", msg.To, want)
+	}
+	if want := "[CRITICAL] PM2.5 anomaly: 187.50 µg/m³"; msg.Subject != want {
+		t.Errorf("subject = %q, want %q", msg.Subject, want)
+	}
+	for _, part := range []string{"text/plain", "text/html", "http://dashboard.example.com"} {
+		if !strings.Contains(msg.Body, part) {
+			t.Errorf("body does not contain %q", part)
+		}
+	}
+}
+
+func TestNotifyAnomalyFiltersAndDeduplicates(t *testing.T) {
+	sink := newSMTPSink(t)
+	config := sink.config()
+	config.DigestWindow = 0
+	notifier := NewNotifier(config)
+
+	low := criticalAnomaly(2, 40)
+	low.Severity = "low"
+	for _, anomaly := range []models.Anomaly{low, criticalAnomaly(3, 120), criticalAnomaly(3, 120)} {
+		if err := notifier.NotifyAnomaly(anomaly); err != nil {
+			t.Fatalf("NotifyAnomaly returned error: %v", err)
+		}
+	}
+
+	if sent := sink.messages(); len(sent) != 1 {
+		t.Errorf("sink received %d messages, want only the first critical alert", len(sent))
+	}
+}
+
+func TestNotifyAnomalyCollectsDigest(t *testing.T) {
+	sink := newSMTPSink(t)
+	notifier := NewNotifier(sink.config())
+
+	for id := int64(1); id <= 3; id++ {
+		if err := notifier.NotifyAnomaly(criticalAnomaly(id, 100+float64(id))); err != nil {
+			t.Fatalf("NotifyAnomaly returned error: %v", err)
+		}
+	}
+	if sent := sink.messages(); len(sent) != 1 {
+		t.Fatalf("sink received %d messages within the digest window, want 1", len(sent))
+	}
+
+	notifier.flush()
+	if sent := sink.messages(); len(sent) != 1 {
+		t.Fatalf("digest was sent before the window passed")
+	}
+
+	notifier.mu.Lock()
+	notifier.lastSent = time.Now().Add(-2 * time.Hour)
+	notifier.mu.Unlock()
+	notifier.flush()
+
+	sent := sink.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sink received %d messages, want the alert and one digest", len(sent))
+	}
+	if want := "[DIGEST] 2 air quality anomalies"; sent[1].Subject != want {
+		t.Errorf("digest subject = %q, want %q", sent[1].Subject, want)
+	}
+}
+
+func TestNotifyAnomalyRetriesAfterSMTPFailure(t *testing.T) {
+	sink := newSMTPSink(t)
+	sink.reject["oncall@example.com"] = true
+	notifier := NewNotifier(sink.config())
+
+	if err := notifier.NotifyAnomaly(criticalAnomaly(4, 150)); err == nil {
+		t.Fatal("NotifyAnomaly returned no error for a refused recipient")
+	}
+
+	delete(sink.reject, "oncall@example.com")
+	notifier.mu.Lock()
+	notifier.lastSent = time.Time{}
+	notifier.mu.Unlock()
+
+	if err := notifier.NotifyAnomaly(criticalAnomaly(4, 150)); err != nil {
+		t.Fatalf("retried NotifyAnomaly returned error: %v", err)
+	}
+	if sent := sink.messages(); len(sent) != 1 {
+		t.Errorf("sink received %d messages, want the retried alert", len(sent))
+	}
+}
+
+func TestSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	notifier := NewNotifier(sink.config())
+
+	notification := notify.Notification{Event: "escalation", Level: 2, Anomaly: criticalAnomaly(5, 210)}
+	if err := notifier.Send("managers@example.com", notification); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	sent := sink.messages()
+	if len(sent) != 1 || strings.Join(sent[0].To, ",") != "managers@example.com" {
+		t.Fatalf("sink received %+v, want one message to managers@example.com", sent)
+	}
+	if !strings.HasPrefix(sent[0].Subject, "[ESCALATION 2] ") {
+		t.Errorf("subject = %q, want an escalation subject", sent[0].Subject)
+	}
+
+	if err := notifier.Send("not an address", notification); !errors.Is(err, notify.ErrInvalidRecipient) {
+		t.Errorf("Send to an invalid address error = %v, want ErrInvalidRecipient", err)
+	}
+}
@@ -0,0 +1,136 @@
+package email
+
+import (
+	"api/internal/aqi"
+	"api/internal/models"
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var funcs = map[string]interface{}{
+	"category": func(anomaly models.Anomaly) string {
+		if category := aqi.Category(strings.ToUpper(anomaly.Parameter), anomaly.Value); category != "" {
+			return strings.ReplaceAll(category, "_", " ")
+		}
+		return "unknown"
+	},
+	"mapURL": models.Anomaly.MapURL,
+	"color": func(severity string) string {
+		switch severity {
+		case "critical":
+			return "#b71c1c"
+		case "high":
+			return "#e65100"
+		}
+		return "#555555"
+	},
+}
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.New("").Funcs(funcs).ParseFS(templateFiles, "templates/*.txt.tmpl"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(funcs).ParseFS(templateFiles, "templates/*.html.tmpl"))
+)
+
+type alertData struct {
+	Anomaly      models.Anomaly
+	Level        int
+	DashboardURL string
+}
+
+type digestData struct {
+	Anomalies    []models.Anomaly
+	Since        time.Time
+	DashboardURL string
+}
+
+// message is a rendered email with a plain text and an HTML part.
+type message struct {
+	Subject string
+	Text    []byte
+	HTML    []byte
+}
+
+func render(name, subject string, data interface{}) (message, error) {
+	msg := message{Subject: subject}
+
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt.tmpl", data); err != nil {
+		return msg, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html.tmpl", data); err != nil {
+		return msg, err
+	}
+	msg.Text, msg.HTML = text.Bytes(), html.Bytes()
+	return msg, nil
+}
+
+func alertMessage(anomaly models.Anomaly, level int, dashboardURL string) (message, error) {
+	subject := fmt.Sprintf("[%s] %s anomaly: %.2f µg/m³", strings.ToUpper(anomaly.Severity), anomaly.Parameter, anomaly.Value)
+	if level > 0 {
+		subject = fmt.Sprintf("[ESCALATION %d] %s", level, subject)
+	}
+	return render("alert", subject, alertData{Anomaly: anomaly, Level: level, DashboardURL: dashboardURL})
+}
+
+func digestMessage(anomalies []models.Anomaly, since time.Time, dashboardURL string) (message, error) {
+	subject := fmt.Sprintf("[DIGEST] %d air quality anomalies", len(anomalies))
+	return render("digest", subject, digestData{Anomalies: anomalies, Since: since, DashboardURL: dashboardURL})
+}
+
+// build encodes the message as a multipart/alternative MIME message.
+func (m message) build(from string, to []string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package escalation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Step notifies Target once an anomaly has stayed open for Delay after it
+// was detected.
+type Step struct {
+	Delay  time.Duration
+	Target string
+}
+
+// Policy is the escalation chain for one severity. After the last step the
+// last target is notified again every Repeat until the anomaly is
+// acknowledged; a zero Repeat notifies it once.
+type Policy struct {
+	Severity string
+	Steps    []Step
+	Repeat   time.Duration
+}
+
+// ParsePolicies reads policies given as severity=steps pairs separated by
+// commas, with the steps of a policy separated by "|" and written as
+// <delay>:<target>, e.g.
+// "critical=15m:log:oncall|30m:email:managers@example.com,high=1h:log:oncall".
+func ParsePolicies(value string, repeat time.Duration) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		severity, steps, ok := strings.Cut(entry, "=")
+		severity = strings.ToLower(strings.TrimSpace(severity))
+		if !ok || severity == "" {
+			return nil, fmt.Errorf("invalid escalation policy %q", entry)
+		}
+
+		policy := Policy{Severity: severity, Repeat: repeat}
+		for _, step := range strings.Split(steps, "|") {
+			delay, target, ok := strings.Cut(strings.TrimSpace(step), ":")
+			d, err := time.ParseDuration(delay)
+			if !ok || err != nil || d < 0 || !strings.Contains(target, ":") {
+				return nil, fmt.Errorf("invalid escalation step %q (use <delay>:<channel>:<recipient>)", step)
+			}
+			policy.Steps = append(policy.Steps, Step{Delay: d, Target: target})
+		}
+		sort.SliceStable(policy.Steps, func(i, j int) bool { return policy.Steps[i].Delay < policy.Steps[j].Delay })
+		policies[severity] = policy
+	}
+	return policies, nil
+}
+
+// Next returns the step due for an anomaly detected at detected that has
+// been escalated level times, the last time at escalatedAt, and when it is
+// due. ok is false when nothing more is to be sent.
+func (p Policy) Next(detected time.Time, level int, escalatedAt *time.Time) (step Step, due time.Time, ok bool) {
+	if len(p.Steps) == 0 {
+		return Step{}, time.Time{}, false
+	}
+	if level < len(p.Steps) {
+		step = p.Steps[level]
+		return step, detected.Add(step.Delay), true
+	}
+	if p.Repeat <= 0 || escalatedAt == nil {
+		return Step{}, time.Time{}, false
+	}
+	return p.Steps[len(p.Steps)-1], escalatedAt.Add(p.Repeat), true
+}
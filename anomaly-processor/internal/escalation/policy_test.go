@@ -0,0 +1,105 @@
+package escalation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]Policy
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string]Policy{},
+		},
+		{
+			name:  "several severities with steps sorted by delay",
+			value: "Critical = 30m:email:managers@example.com|15m:log:oncall , high=1h:webhook:3",
+			want: map[string]Policy{
+				"critical": {Severity: "critical", Repeat: time.Hour, Steps: []Step{
+					{Delay: 15 * time.Minute, Target: "log:oncall"},
+					{Delay: 30 * time.Minute, Target: "email:managers@example.com"},
+				}},
+				"high": {Severity: "high", Repeat: time.Hour, Steps: []Step{
+					{Delay: time.Hour, Target: "webhook:3"},
+				}},
+			},
+		},
+		{
+			name:  "immediate step and trailing comma",
+			value: "medium=0s:log:oncall,",
+			want: map[string]Policy{
+				"medium": {Severity: "medium", Repeat: time.Hour, Steps: []Step{{Target: "log:oncall"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicies(tt.value, time.Hour)
+			if err != nil {
+				t.Fatalf("ParsePolicies(%q) returned error: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePolicies(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePoliciesErrors(t *testing.T) {
+	for _, value := range []string{
+		"critical",
+		"=15m:log:oncall",
+		"critical=",
+		"critical=15m",
+		"critical=15m:oncall",
+		"critical=soon:log:oncall",
+		"critical=-5m:log:oncall",
+		"critical=15m:log:oncall||30m:log:oncall",
+	} {
+		if _, err := ParsePolicies(value, time.Hour); err == nil {
+			t.Errorf("ParsePolicies(%q) returned no error", value)
+		}
+	}
+}
+
+func TestPolicyNext(t *testing.T) {
+	detected := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	escalated := detected.Add(40 * time.Minute)
+	policy := Policy{Severity: "critical", Repeat: time.Hour, Steps: []Step{
+		{Delay: 15 * time.Minute, Target: "log:oncall"},
+		{Delay: 30 * time.Minute, Target: "email:managers@example.com"},
+	}}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		level       int
+		escalatedAt *time.Time
+		wantTarget  string
+		wantDue     time.Time
+		wantOK      bool
+	}{
+		{"first step from detection", policy, 0, nil, "log:oncall", detected.Add(15 * time.Minute), true},
+		{"second step from detection", policy, 1, &escalated, "email:managers@example.com", detected.Add(30 * time.Minute), true},
+		{"repeat last step", policy, 2, &escalated, "email:managers@example.com", escalated.Add(time.Hour), true},
+		{"repeat without previous escalation", policy, 2, nil, "", time.Time{}, false},
+		{"no repeat after last step", Policy{Steps: policy.Steps}, 2, &escalated, "", time.Time{}, false},
+		{"no steps", Policy{Repeat: time.Hour}, 0, nil, "", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, due, ok := tt.policy.Next(detected, tt.level, tt.escalatedAt)
+			if ok != tt.wantOK || step.Target != tt.wantTarget || !due.Equal(tt.wantDue) {
+				t.Errorf("Next(level %d) = %q, %v, %v; want %q, %v, %v", tt.level, step.Target, due, ok, tt.wantTarget, tt.wantDue, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package escalation
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"api/internal/repository"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultRepeat   = 30 * time.Minute
+	defaultMaxAge   = 24 * time.Hour
+	defaultLease    = 2 * time.Minute
+)
+
+// Scheduler periodically escalates anomalies that are still open: each
+// step of the anomaly's severity policy is sent once its delay since
+// detection has passed, and the last one again every Repeat. Anomalies
+// older than MaxAge are left alone so old backlogs are not escalated. A
+// step is claimed for Lease while it is sent, which is also how long a
+// failed step waits before it is tried again.
+type Scheduler struct {
+	Db         *sql.DB
+	Dispatcher *notify.Dispatcher
+	Policies   map[string]Policy
+	Interval   time.Duration
+	MaxAge     time.Duration
+	Lease      time.Duration
+
+	anomalyRepository *repository.AnomalyRepository
+}
+
+func NewScheduler(db *sql.DB, dispatcher *notify.Dispatcher, policies map[string]Policy) *Scheduler {
+	return &Scheduler{
+		Db:                db,
+		Dispatcher:        dispatcher,
+		Policies:          policies,
+		Interval:          defaultInterval,
+		MaxAge:            defaultMaxAge,
+		Lease:             defaultLease,
+		anomalyRepository: repository.NewAnomalyRepository(db),
+	}
+}
+
+// NewSchedulerFromEnv builds a scheduler from ESCALATION_POLICIES,
+// ESCALATION_REPEAT (default 30m, 0 to notify the last step once) and
+// ESCALATION_MAX_AGE (default 24h). It returns nil when no policies are set.
+func NewSchedulerFromEnv(db *sql.DB, dispatcher *notify.Dispatcher) (*Scheduler, error) {
+	repeat := defaultRepeat
+	if value := os.Getenv("ESCALATION_REPEAT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ESCALATION_REPEAT %q", value)
+		}
+		repeat = d
+	}
+
+	policies, err := ParsePolicies(os.Getenv("ESCALATION_POLICIES"), repeat)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	for _, policy := range policies {
+		for _, step := range policy.Steps {
+			if !dispatcher.Has(step.Target) {
+				return nil, fmt.Errorf("escalation policy %s: unknown notification channel in %q", policy.Severity, step.Target)
+			}
+		}
+	}
+
+	s := NewScheduler(db, dispatcher, policies)
+	if value := os.Getenv("ESCALATION_MAX_AGE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ESCALATION_MAX_AGE %q", value)
+		}
+		s.MaxAge = d
+	}
+	return s, nil
+}
+
+func (s *Scheduler) Start() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.run(time.Now()); err != nil {
+			log.Printf("Escalation scheduler: %v", err)
+		}
+	}
+}
+
+func (s *Scheduler) run(now time.Time) error {
+	severities := make([]string, 0, len(s.Policies))
+	for severity := range s.Policies {
+		severities = append(severities, severity)
+	}
+
+	candidates, err := s.anomalyRepository.PendingEscalations(severities, now.Add(-s.MaxAge))
+	if err != nil {
+		return err
+	}
+
+	for _, anomaly := range candidates {
+		if _, ok := s.due(anomaly, now); !ok {
+			continue
+		}
+		if err := s.escalate(anomaly, now); err != nil {
+			log.Printf("Escalation scheduler: %v", err)
+		}
+	}
+	return nil
+}
+
+// due returns the anomaly's next escalation step if it is due.
+func (s *Scheduler) due(anomaly models.Anomaly, now time.Time) (Step, bool) {
+	if anomaly.DetectedAt == nil {
+		return Step{}, false
+	}
+	step, due, ok := s.Policies[anomaly.Severity].Next(*anomaly.DetectedAt, anomaly.EscalationLevel, anomaly.EscalatedAt)
+	return step, ok && !now.Before(due)
+}
+
+// escalate claims the next step of one anomaly, sends it and records it.
+// The claim is committed before sending, so a slow or unreachable target
+// holds no row lock and does not hold up acknowledgements or other
+// instances. A step that fails to send is not recorded and is retried once
+// the claim's lease ends.
+func (s *Scheduler) escalate(candidate models.Anomaly, now time.Time) error {
+	anomaly, claimed, err := s.anomalyRepository.ClaimEscalation(candidate.ID, candidate.EscalationLevel, s.Lease)
+	if err != nil || !claimed {
+		return err
+	}
+	step, ok := s.due(anomaly, now)
+	if !ok {
+		return nil
+	}
+
+	level := anomaly.EscalationLevel + 1
+	anomaly.EscalationLevel = level
+	notification := notify.Notification{Event: notify.EventEscalation, Level: level, Anomaly: anomaly}
+	if err := s.Dispatcher.Send(step.Target, notification); err != nil {
+		log.Printf("Escalation of anomaly %d to %s failed, retrying in %s: %v", anomaly.ID, step.Target, s.Lease, err)
+		return nil
+	}
+	if err := s.anomalyRepository.RecordEscalation(anomaly.ID, level, step.Target); err != nil {
+		return err
+	}
+	log.Printf("Escalated anomaly %d to level %d (%s)", anomaly.ID, level, step.Target)
+	return nil
+}
@@ -1,10 +1,70 @@
 package models
 
+import (
+	"fmt"
+	"time"
+)
+
 type Anomaly struct {
+	ID          int64   `json:"id"`
 	Parameter   string  `json:"parameter"`
 	Value       float64 `json:"value"`
 	Time        string  `json:"time"`
 	Longitude   float64 `json:"longitude"`
 	Latitude    float64 `json:"latitude"`
 	Description string  `json:"description"`
+	Severity    string  `json:"severity,omitempty"`
+	Threshold   float64 `json:"threshold,omitempty"`
+
+	Status          string     `json:"status"`
+	Assignee        string     `json:"assignee,omitempty"`
+	Notes           string     `json:"notes,omitempty"`
+	AcknowledgedAt  *time.Time `json:"acknowledgedAt,omitempty"`
+	ResolvedAt      *time.Time `json:"resolvedAt,omitempty"`
+	StatusUpdatedAt *time.Time `json:"statusUpdatedAt,omitempty"`
+	EscalationLevel int        `json:"escalationLevel,omitempty"`
+	EscalatedAt     *time.Time `json:"escalatedAt,omitempty"`
+	DetectedAt      *time.Time `json:"detectedAt,omitempty"`
+}
+
+// MapURL links to the anomaly's location on OpenStreetMap.
+func (a Anomaly) MapURL() string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.5f&mlon=%.5f#map=14/%.5f/%.5f",
+		a.Latitude, a.Longitude, a.Latitude, a.Longitude)
+}
+
+// StatusChange moves an anomaly to a new status. Assignee and Notes replace
+// the current values when set; an empty string clears them.
+type StatusChange struct {
+	Status   string
+	Assignee *string
+	Notes    *string
+	Actor    string
+}
+
+// AnomalyEvent is one entry of an anomaly's history.
+type AnomalyEvent struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	FromStatus string    `json:"fromStatus,omitempty"`
+	ToStatus   string    `json:"toStatus,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Assignee   string    `json:"assignee,omitempty"`
+	Note       string    `json:"note,omitempty"`
+}
+
+// AnomalyPage is one page of a filtered anomaly listing. NextCursor is empty
+// on the last page.
+type AnomalyPage struct {
+	Items      []Anomaly `json:"items"`
+	Total      int64     `json:"total"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// AnomalyDetail is an anomaly together with the readings taken at its
+// location around the time it was detected.
+type AnomalyDetail struct {
+	Anomaly
+	Measurements []Reading `json:"measurements"`
 }
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// MeasurementBucket aggregates the readings of one parameter within one
+// time bucket.
+type MeasurementBucket struct {
+	Time      time.Time `json:"time"`
+	Parameter string    `json:"parameter"`
+	Avg       float64   `json:"avg"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	P95       float64   `json:"p95"`
+	Count     int64     `json:"count"`
+}
+
+// LatestReading is the most recent reading of one parameter at a location.
+type LatestReading struct {
+	Parameter string    `json:"parameter"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	Category  string    `json:"category,omitempty"`
+}
+
+// LocationSnapshot is the current air quality at a sensor or, for readings
+// without a sensor ID, at a coordinate. Category is the worst category of
+// its readings.
+type LocationSnapshot struct {
+	SensorID  string          `json:"sensorId,omitempty"`
+	Latitude  float64         `json:"latitude"`
+	Longitude float64         `json:"longitude"`
+	Time      time.Time       `json:"time"`
+	Category  string          `json:"category,omitempty"`
+	Readings  []LatestReading `json:"readings"`
+}
+
+// Reading is a single stored reading.
+type Reading struct {
+	SensorID  string    `json:"sensorId,omitempty"`
+	Parameter string    `json:"parameter"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Point is a location in degrees.
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// QuietHours is a daily window, in HH:MM local time of Timezone, during
+// which a subscription receives no alerts. End before Start spans midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// Subscription asks for alerts about anomalies inside an area: either Area,
+// a GeoJSON Polygon or MultiPolygon, or Center with Radius in kilometres.
+// Empty Parameters match every pollutant. Channel is a notification target
+// such as "email:ayse@example.com" or "webhook:3".
+type Subscription struct {
+	ID          int64           `json:"id"`
+	UserID      string          `json:"userId"`
+	Name        string          `json:"name,omitempty"`
+	Area        json.RawMessage `json:"area,omitempty"`
+	Center      *Point          `json:"center,omitempty"`
+	Radius      float64         `json:"radius,omitempty"`
+	Parameters  []string        `json:"parameters"`
+	MinSeverity string          `json:"minSeverity"`
+	QuietHours  *QuietHours     `json:"quietHours,omitempty"`
+	Channel     string          `json:"channel"`
+	Enabled     bool            `json:"enabled"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
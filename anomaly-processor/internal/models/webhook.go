@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// BoundingBox is a rectangular region in degrees.
+type BoundingBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLon float64 `json:"minLon"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLon float64 `json:"maxLon"`
+}
+
+func (b BoundingBox) Valid() bool {
+	return b.MinLat < b.MaxLat && b.MinLon < b.MaxLon &&
+		b.MinLat >= -90 && b.MaxLat <= 90 && b.MinLon >= -180 && b.MaxLon <= 180
+}
+
+// Webhook is a subscriber endpoint. Format selects the body posted to it:
+// the JSON payload, or a Slack or Microsoft Teams message. Empty Parameters
+// or Severities and a nil Region match every anomaly. Secret is only
+// returned when the webhook is created.
+type Webhook struct {
+	ID                  int64        `json:"id"`
+	URL                 string       `json:"url"`
+	Format              string       `json:"format"`
+	Secret              string       `json:"secret,omitempty"`
+	Description         string       `json:"description,omitempty"`
+	Parameters          []string     `json:"parameters"`
+	Severities          []string     `json:"severities"`
+	Region              *BoundingBox `json:"region,omitempty"`
+	Enabled             bool         `json:"enabled"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	DisabledAt          *time.Time   `json:"disabledAt,omitempty"`
+	CreatedAt           time.Time    `json:"createdAt"`
+}
+
+// WebhookDelivery is one entry of a webhook's delivery log.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      int64      `json:"webhookId"`
+	AnomalyID      int64      `json:"anomalyId"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
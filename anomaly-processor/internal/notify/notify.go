@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"api/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Notification events.
+const (
+	EventAnomaly      = "anomaly"
+	EventEscalation   = "escalation"
+	EventSubscription = "subscription"
+)
+
+// ErrInvalidRecipient marks a target that can never be delivered to, e.g.
+// a malformed email address or a deleted webhook; retrying does not help.
+var ErrInvalidRecipient = errors.New("invalid recipient")
+
+// Notification is what a channel delivers: the anomaly and why it is being
+// sent. Level is the escalation level for escalations.
+type Notification struct {
+	Event   string
+	Level   int
+	Anomaly models.Anomaly
+}
+
+// Channel delivers notifications to recipients of one kind, e.g. email
+// addresses or chat webhooks.
+type Channel interface {
+	Send(recipient string, notification Notification) error
+}
+
+// Validator is implemented by channels that can check a recipient before
+// anything is sent to it.
+type Validator interface {
+	Validate(recipient string) error
+}
+
+// Notifier is told about every newly stored anomaly and decides itself who
+// receives it, e.g. the webhooks whose filters match. A failed call is
+// repeated, so a notifier should not send twice what it already sent.
+type Notifier interface {
+	NotifyAnomaly(anomaly models.Anomaly) error
+}
+
+// Dispatcher routes notifications to channels by target and hands new
+// anomalies to the registered notifiers. A target is
+// "<channel>:<recipient>", e.g. "log:oncall"; the recipient is interpreted
+// by the channel.
+type Dispatcher struct {
+	mu        sync.RWMutex
+	channels  map[string]Channel
+	notifiers []Notifier
+}
+
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{channels: make(map[string]Channel)}
+	d.Register("log", LogChannel{})
+	return d
+}
+
+func (d *Dispatcher) Register(name string, channel Channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[name] = channel
+}
+
+func (d *Dispatcher) AddNotifier(notifier Notifier) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notifiers = append(d.notifiers, notifier)
+}
+
+// NotifyAnomaly calls every notifier, also when one of them fails, and
+// returns their errors joined.
+func (d *Dispatcher) NotifyAnomaly(anomaly models.Anomaly) error {
+	d.mu.RLock()
+	notifiers := d.notifiers
+	d.mu.RUnlock()
+
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.NotifyAnomaly(anomaly); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Has reports whether a channel with the target's name is registered.
+func (d *Dispatcher) Has(target string) bool {
+	name, _, _ := strings.Cut(target, ":")
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.channels[name]
+	return ok
+}
+
+// Validate checks that the target's channel is registered, that it names a
+// recipient and, for channels that implement Validator, that the recipient
+// is valid. Invalid targets are reported with errors wrapping
+// ErrInvalidRecipient.
+func (d *Dispatcher) Validate(target string) error {
+	name, recipient, _ := strings.Cut(target, ":")
+	channel, err := d.channel(name)
+	if err != nil {
+		return err
+	}
+	if recipient == "" {
+		return fmt.Errorf("%w: %q has no recipient", ErrInvalidRecipient, target)
+	}
+	if validator, ok := channel.(Validator); ok {
+		return validator.Validate(recipient)
+	}
+	return nil
+}
+
+func (d *Dispatcher) Send(target string, notification Notification) error {
+	name, recipient, _ := strings.Cut(target, ":")
+	channel, err := d.channel(name)
+	if err != nil {
+		return err
+	}
+	return channel.Send(recipient, notification)
+}
+
+func (d *Dispatcher) channel(name string) (Channel, error) {
+	d.mu.RLock()
+	channel, ok := d.channels[name]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown notification channel %q", ErrInvalidRecipient, name)
+	}
+	return channel, nil
+}
+
+// LogChannel writes notifications to the service log. It is always
+// registered as "log" and is useful for trying out escalation policies.
+type LogChannel struct{}
+
+func (LogChannel) Send(recipient string, n Notification) error {
+	log.Printf("Notification (%s, level %d) to %s: anomaly %d %s=%.2f (%s, %s, status %s)",
+		n.Event, n.Level, recipient, n.Anomaly.ID, n.Anomaly.Parameter, n.Anomaly.Value,
+		n.Anomaly.Description, n.Anomaly.Severity, n.Anomaly.Status)
+	return nil
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"errors"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// BroadcastExchange is a fanout exchange carrying anomalies that have been
+// persisted. Every anomaly-processor instance binds its own exclusive queue
+// to it, so each instance's WebSocket clients receive every alert.
+const BroadcastExchange = "anomaly_broadcast"
+
+type Broadcaster struct {
+	QueueConn *amqp.Connection
+}
+
+func NewBroadcaster(queueConn *amqp.Connection) *Broadcaster {
+	return &Broadcaster{
+		QueueConn: queueConn,
+	}
+}
+
+// Publish sends an anomaly to every instance and waits for the broker to
+// confirm it, so a lost broadcast is reported as an error.
+func (b *Broadcaster) Publish(body []byte) error {
+	ch, err := b.QueueConn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	err = ch.Publish(
+		BroadcastExchange,
+		"",
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case confirm, ok := <-confirms:
+		if !ok {
+			return errors.New("channel closed before confirmation")
+		}
+		if !confirm.Ack {
+			return errors.New("broker rejected broadcast")
+		}
+		return nil
+	case <-time.After(confirmTimeout):
+		return errors.New("timed out waiting for confirmation")
+	}
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// An alert that could not be stored or notified is published again at the
+// back of its queue with AttemptsHeader raised. After MaxAttempts it goes to
+// the dead letter exchange, which the measurement processor declares too,
+// under its original routing key.
+const (
+	DeadLetterExchange = "air_quality.dead_letter"
+	DeadLetterQueue    = "dead_letters"
+	DeadLetterBinding  = "#"
+
+	AttemptsHeader   = "x-attempts"
+	RoutingKeyHeader = "x-original-routing-key"
+	ErrorHeader      = "x-last-error"
+
+	MaxAttempts = 5
+
+	confirmTimeout = 10 * time.Second
+)
+
+// Retrier settles failed deliveries of one queue.
+type Retrier struct {
+	QueueConn *amqp.Connection
+	Queue     string
+
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func NewRetrier(queueConn *amqp.Connection, queue string) *Retrier {
+	return &Retrier{
+		QueueConn: queueConn,
+		Queue:     queue,
+	}
+}
+
+// Attempts returns how many times a delivery has already failed.
+func Attempts(d amqp.Delivery) int {
+	switch n := d.Headers[AttemptsHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// Retry republishes a delivery that failed with cause, or dead-letters it
+// once it has failed MaxAttempts times, and acknowledges the original. If
+// the copy cannot be published the delivery is requeued instead.
+func (r *Retrier) Retry(d amqp.Delivery, cause error) {
+	attempts := Attempts(d) + 1
+	if attempts >= MaxAttempts {
+		r.DeadLetter(d, cause)
+		return
+	}
+
+	log.Printf("Retrying message %s on %s (attempt %d of %d): %v", d.MessageId, r.Queue, attempts, MaxAttempts, cause)
+	r.republish(d, "", r.Queue, attempts, cause)
+}
+
+// DeadLetter moves a delivery to the dead letter queue, e.g. one that can
+// never be decoded, and acknowledges the original.
+func (r *Retrier) DeadLetter(d amqp.Delivery, cause error) {
+	log.Printf("Dead-lettering message %s from %s: %v", d.MessageId, r.Queue, cause)
+	r.republish(d, DeadLetterExchange, originalRoutingKey(d), Attempts(d)+1, cause)
+}
+
+func (r *Retrier) republish(d amqp.Delivery, exchange, routingKey string, attempts int, cause error) {
+	headers := amqp.Table{}
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[AttemptsHeader] = int32(attempts)
+	headers[RoutingKeyHeader] = originalRoutingKey(d)
+	headers[ErrorHeader] = cause.Error()
+
+	err := r.publish(exchange, routingKey, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		log.Printf("Failed to republish message %s, requeueing it: %v", d.MessageId, err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+func originalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[RoutingKeyHeader].(string); ok {
+		return key
+	}
+	return d.RoutingKey
+}
+
+// publish sends one message on a confirming channel and waits for the
+// broker's confirmation, so the original is only acknowledged once its copy
+// is safe.
+func (r *Retrier) publish(exchange, routingKey string, msg amqp.Publishing) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ch == nil {
+		ch, err := r.QueueConn.Channel()
+		if err != nil {
+			return err
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return err
+		}
+		r.ch = ch
+		r.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+
+	err := r.ch.Publish(exchange, routingKey, false, false, msg)
+	if err == nil {
+		select {
+		case confirm, ok := <-r.confirms:
+			switch {
+			case !ok:
+				err = errors.New("channel closed before confirmation")
+			case !confirm.Ack:
+				err = errors.New("broker rejected message")
+			}
+		case <-time.After(confirmTimeout):
+			err = errors.New("timed out waiting for confirmation")
+		}
+	}
+	if err != nil {
+		// The channel's state is unknown; open a fresh one next time.
+		r.ch.Close()
+		r.ch = nil
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// Anomaly alerts are published to the topic exchange as
+// anomaly.<severity>.<parameter>. Critical alerts are routed only to
+// anomaly_alerts_critical, which is consumed separately so they skip any
+// backlog; alerts of lower severity go to anomaly_alerts.
+const (
+	Exchange             = "air_quality"
+	AnomalyAlertsQueue   = "anomaly_alerts"
+	CriticalAlertsQueue  = "anomaly_alerts_critical"
+	CriticalAlertBinding = "anomaly.critical.#"
+
+	// legacyAnomalyBinding bound anomaly_alerts to every alert, critical
+	// ones included. Bindings outlive the services, so it is removed from
+	// brokers that still have it.
+	legacyAnomalyBinding = "anomaly.#"
+)
+
+// AnomalyBindings route the severities below critical to anomaly_alerts.
+var AnomalyBindings = []string{"anomaly.low.#", "anomaly.medium.#", "anomaly.high.#"}
+
+// DeclareTopology declares the exchanges, the alert queues with their
+// bindings and the dead letter queue.
+func DeclareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, exchange := range []string{Exchange, DeadLetterExchange} {
+		if err := ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+		}
+	}
+	if err := ch.ExchangeDeclare(BroadcastExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", BroadcastExchange, err)
+	}
+	for name, patterns := range map[string][]string{
+		AnomalyAlertsQueue:  AnomalyBindings,
+		CriticalAlertsQueue: {CriticalAlertBinding},
+	} {
+		if _, err := ch.QueueDeclare(name, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", name, err)
+		}
+		for _, pattern := range patterns {
+			if err := ch.QueueBind(name, pattern, Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %w", name, pattern, err)
+			}
+		}
+	}
+	if err := ch.QueueUnbind(AnomalyAlertsQueue, legacyAnomalyBinding, Exchange, nil); err != nil {
+		return fmt.Errorf("failed to unbind queue %s from %s: %w", AnomalyAlertsQueue, legacyAnomalyBinding, err)
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", DeadLetterQueue, err)
+	}
+	if err := ch.QueueBind(DeadLetterQueue, DeadLetterBinding, DeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %w", DeadLetterQueue, err)
+	}
+
+	return nil
+}
@@ -3,10 +3,12 @@ package repository
 import (
 	"api/internal/models"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
+
+	"contracts/messages"
 )
 
 type AnomalyRepository struct {
@@ -17,37 +19,148 @@ func NewAnomalyRepository(db *sql.DB) *AnomalyRepository {
 	return &AnomalyRepository{Db: db}
 }
 
-func (r *AnomalyRepository) SaveAnomalyToDB(message []byte) {
-	query := `INSERT INTO anomalies (parameter, value, time, location, description) VALUES ($1, $2, $3, ST_SetSRID(ST_MakePoint($4, $5), 4326), $6)`
+// anomalyColumns is the select list read by scanAnomaly.
+const anomalyColumns = `
+			   id, parameter, value, time,
+			   ST_X(location::geometry) AS longitude,
+			   ST_Y(location::geometry) AS latitude,
+			   description,
+			   COALESCE(severity, ''),
+			   COALESCE(threshold, 0),
+			   status,
+			   COALESCE(assignee, ''),
+			   COALESCE(notes, ''),
+			   acknowledged_at,
+			   resolved_at,
+			   status_updated_at,
+			   escalation_level,
+			   escalated_at,
+			   detected_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAnomaly(row scanner) (models.Anomaly, error) {
+	anomaly, _, err := scanAnomalyTime(row)
+	return anomaly, err
+}
 
+// scanAnomalyTime also returns the anomaly's time at full precision, which
+// the RFC3339 string in the model drops.
+func scanAnomalyTime(row scanner) (models.Anomaly, time.Time, error) {
 	var anomaly models.Anomaly
+	var anomalyTime time.Time
+	var acknowledgedAt, resolvedAt, statusUpdatedAt, escalatedAt, detectedAt sql.NullTime
+	err := row.Scan(&anomaly.ID, &anomaly.Parameter, &anomaly.Value, &anomalyTime, &anomaly.Longitude, &anomaly.Latitude,
+		&anomaly.Description, &anomaly.Severity, &anomaly.Threshold, &anomaly.Status, &anomaly.Assignee, &anomaly.Notes,
+		&acknowledgedAt, &resolvedAt, &statusUpdatedAt, &anomaly.EscalationLevel, &escalatedAt, &detectedAt)
+	if err != nil {
+		return anomaly, anomalyTime, err
+	}
+	anomaly.Time = anomalyTime.Format(time.RFC3339)
+	anomaly.AcknowledgedAt = timePointer(acknowledgedAt)
+	anomaly.ResolvedAt = timePointer(resolvedAt)
+	anomaly.StatusUpdatedAt = timePointer(statusUpdatedAt)
+	anomaly.EscalatedAt = timePointer(escalatedAt)
+	anomaly.DetectedAt = timePointer(detectedAt)
+	return anomaly, anomalyTime, nil
+}
+
+func timePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+// SaveAnomalyToDB upserts the anomaly by its ID and returns the row ID and
+// whether it was new. A redelivered anomaly updates the existing row instead
+// of adding a second one.
+func (r *AnomalyRepository) SaveAnomalyToDB(anomalyID string, anomaly messages.Anomaly) (int64, bool, error) {
+	query := `
+		INSERT INTO anomalies (anomaly_id, parameter, value, time, location, description, severity, threshold)
+		VALUES ($1, $2, $3, $4, ST_SetSRID(ST_MakePoint($5, $6), 4326), $7, NULLIF($8, ''), NULLIF($9, 0))
+		ON CONFLICT (anomaly_id) DO UPDATE
+			SET parameter   = EXCLUDED.parameter,
+			    value       = EXCLUDED.value,
+			    time        = EXCLUDED.time,
+			    location    = EXCLUDED.location,
+			    description = EXCLUDED.description,
+			    severity    = EXCLUDED.severity,
+			    threshold   = EXCLUDED.threshold
+		RETURNING id, (xmax = 0) AS inserted`
+
+	var id int64
+	var inserted bool
+	err := r.Db.QueryRow(query, anomalyID, anomaly.Parameter, anomaly.Value, anomaly.Timestamp, anomaly.Longitude, anomaly.Latitude, anomaly.Description, anomaly.Severity, anomaly.Threshold).Scan(&id, &inserted)
+	if err != nil {
+		return 0, false, fmt.Errorf("error saving anomaly %s to DB: %w", anomalyID, err)
+	}
+	return id, inserted, nil
+}
 
-	err := json.Unmarshal(message, &anomaly)
+// ClaimNotification marks the anomaly as notified and reports whether this
+// call did so, so that an anomaly arriving through both alert queues is
+// handed to the notifiers once.
+func (r *AnomalyRepository) ClaimNotification(id int64) (bool, error) {
+	result, err := r.Db.Exec(`UPDATE anomalies SET notified_at = now() WHERE id = $1 AND notified_at IS NULL`, id)
 	if err != nil {
-		log.Println("Error parsing anomaly message:", err)
-		return
+		return false, fmt.Errorf("error claiming notification of anomaly %d: %w", id, err)
+	}
+	claimed, err := result.RowsAffected()
+	return claimed > 0, err
+}
+
+// ReleaseNotification undoes ClaimNotification after the notifiers failed,
+// so the redelivered alert is notified again.
+func (r *AnomalyRepository) ReleaseNotification(id int64) error {
+	if _, err := r.Db.Exec(`UPDATE anomalies SET notified_at = NULL WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error releasing notification of anomaly %d: %w", id, err)
 	}
+	return nil
+}
 
-	if anomaly.Time == "" {
-		anomaly.Time = time.Now().UTC().Format(time.RFC3339)
+// ClaimBroadcast marks the anomaly as broadcast and reports whether this
+// call did so, so that a redelivered alert is broadcast only if the first
+// attempt did not get through.
+func (r *AnomalyRepository) ClaimBroadcast(id int64) (bool, error) {
+	result, err := r.Db.Exec(`UPDATE anomalies SET broadcast_at = now() WHERE id = $1 AND broadcast_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("error claiming broadcast of anomaly %d: %w", id, err)
 	}
+	claimed, err := result.RowsAffected()
+	return claimed > 0, err
+}
 
-	if anomaly.Value == 0 {
-		log.Println("Invalid or empty value in anomaly message")
-		return
+// ReleaseBroadcast undoes ClaimBroadcast after publishing failed.
+func (r *AnomalyRepository) ReleaseBroadcast(id int64) error {
+	if _, err := r.Db.Exec(`UPDATE anomalies SET broadcast_at = NULL WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error releasing broadcast of anomaly %d: %w", id, err)
 	}
+	return nil
+}
 
-	_, err = r.Db.Exec(query, anomaly.Parameter, anomaly.Value, anomaly.Time, anomaly.Longitude, anomaly.Latitude, anomaly.Description)
+// GetAnomalyByID loads one anomaly. The second result is false when there is
+// no anomaly with that ID.
+func (r *AnomalyRepository) GetAnomalyByID(id int64) (models.Anomaly, bool, error) {
+	anomaly, err := scanAnomaly(r.Db.QueryRow(`SELECT `+anomalyColumns+` FROM anomalies WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return anomaly, false, nil
+	}
 	if err != nil {
-		log.Println("Error saving anomaly to DB:", err)
+		log.Printf("Error querying anomaly %d: %v", id, err)
+		return anomaly, false, err
 	}
+
+	return anomaly, true, nil
 }
 
 func (r *AnomalyRepository) GetRecentAnomalies() ([]map[string]interface{}, error) {
-	query := `SELECT parameter, value, time, 
+	query := `SELECT id, parameter, value, time, 
 			ST_X(location::geometry) AS longitude, 
 			ST_Y(location::geometry) AS latitude, 
-			description 
+			description, status 
 			FROM anomalies WHERE time >= NOW() - INTERVAL '2 hours'`
 
 	rows, err := r.Db.Query(query)
@@ -62,17 +175,19 @@ func (r *AnomalyRepository) GetRecentAnomalies() ([]map[string]interface{}, erro
 	for rows.Next() {
 		var anomaly = make(map[string]interface{})
 		var longitude, latitude float64
-		var parameter, value, time, description interface{}
-		if err := rows.Scan(&parameter, &value, &time, &longitude, &latitude, &description); err != nil {
+		var id, parameter, value, time, description, status interface{}
+		if err := rows.Scan(&id, &parameter, &value, &time, &longitude, &latitude, &description, &status); err != nil {
 			log.Println("Error scanning row:", err)
 			return nil, err
 		}
+		anomaly["id"] = id
 		anomaly["parameter"] = parameter
 		anomaly["value"] = value
 		anomaly["time"] = time
 		anomaly["description"] = description
 		anomaly["longitude"] = longitude
 		anomaly["latitude"] = latitude
+		anomaly["status"] = status
 		anomalies = append(anomalies, anomaly)
 	}
 
@@ -86,10 +201,7 @@ func (r *AnomalyRepository) GetRecentAnomalies() ([]map[string]interface{}, erro
 
 func (r *AnomalyRepository) GetAnomaliesByLocation(latitude, longitude, radius float64) ([]models.Anomaly, error) {
 	query := `
-		SELECT parameter, value, time,
-			   ST_X(location::geometry) AS longitude,
-			   ST_Y(location::geometry) AS latitude,
-			   description
+		SELECT ` + anomalyColumns + `
 		FROM anomalies
 		WHERE ST_DWithin(location, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3 * 1000)
 		ORDER BY time DESC;`
@@ -103,13 +215,11 @@ func (r *AnomalyRepository) GetAnomaliesByLocation(latitude, longitude, radius f
 
 	var anomalies []models.Anomaly
 	for rows.Next() {
-		var anomaly models.Anomaly
-		var anomalyTime time.Time
-		if err := rows.Scan(&anomaly.Parameter, &anomaly.Value, &anomalyTime, &anomaly.Longitude, &anomaly.Latitude, &anomaly.Description); err != nil {
+		anomaly, err := scanAnomaly(rows)
+		if err != nil {
 			log.Printf("Error scanning anomaly row: %v", err)
 			return nil, err
 		}
-		anomaly.Time = anomalyTime.Format(time.RFC3339)
 		anomalies = append(anomalies, anomaly)
 	}
 
@@ -123,10 +233,7 @@ func (r *AnomalyRepository) GetAnomaliesByLocation(latitude, longitude, radius f
 
 func (r *AnomalyRepository) GetAnomaliesByTimeRange(startTime, endTime time.Time) ([]models.Anomaly, error) {
 	query := `
-		SELECT parameter, value, time,
-			   ST_X(location::geometry) AS longitude,
-			   ST_Y(location::geometry) AS latitude,
-			   description
+		SELECT ` + anomalyColumns + `
 		FROM anomalies
 		WHERE time >= $1 AND time <= $2
 		ORDER BY time DESC;`
@@ -140,13 +247,11 @@ func (r *AnomalyRepository) GetAnomaliesByTimeRange(startTime, endTime time.Time
 
 	var anomalies []models.Anomaly
 	for rows.Next() {
-		var anomaly models.Anomaly
-		var anomalyTime time.Time
-		if err := rows.Scan(&anomaly.Parameter, &anomaly.Value, &anomalyTime, &anomaly.Longitude, &anomaly.Latitude, &anomaly.Description); err != nil {
+		anomaly, err := scanAnomaly(rows)
+		if err != nil {
 			log.Printf("Error scanning anomaly row: %v", err)
 			return nil, err
 		}
-		anomaly.Time = anomalyTime.Format(time.RFC3339)
 		anomalies = append(anomalies, anomaly)
 	}
 
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"api/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SortByTime  = "time"
+	SortByValue = "value"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AnomalyFilter combines the listing filters; zero values are ignored.
+// Descriptions are compared case-insensitively and must be lower case. Only
+// one of the radius and bounding box filters is applied, the radius first.
+type AnomalyFilter struct {
+	Parameters   []string
+	Descriptions []string
+	Severities   []string
+	Statuses     []string
+	Assignee     string
+
+	Latitude  *float64
+	Longitude *float64
+	Radius    float64
+
+	MinLat, MinLon, MaxLat, MaxLon *float64
+
+	Start time.Time
+	End   time.Time
+
+	SortBy    string
+	Ascending bool
+	Limit     int
+	Cursor    string
+}
+
+// cursor is the position after the last row of a page: its sort key and id,
+// which breaks ties between rows with the same sort key.
+type cursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ListAnomalies returns one page of anomalies matching the filter, using
+// keyset pagination on (sort key, id), and the total number of matches.
+func (r *AnomalyRepository) ListAnomalies(filter AnomalyFilter) (models.AnomalyPage, error) {
+	page := models.AnomalyPage{Items: []models.Anomaly{}}
+
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var conditions []string
+	if len(filter.Parameters) > 0 {
+		conditions = append(conditions, "parameter = ANY("+arg(pq.Array(filter.Parameters))+")")
+	}
+	if len(filter.Descriptions) > 0 {
+		conditions = append(conditions, "lower(description) = ANY("+arg(pq.Array(filter.Descriptions))+")")
+	}
+	if len(filter.Severities) > 0 {
+		conditions = append(conditions, "severity = ANY("+arg(pq.Array(filter.Severities))+")")
+	}
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "status = ANY("+arg(pq.Array(filter.Statuses))+")")
+	}
+	if filter.Assignee != "" {
+		conditions = append(conditions, "assignee = "+arg(filter.Assignee))
+	}
+	switch {
+	case filter.Latitude != nil && filter.Longitude != nil:
+		conditions = append(conditions, fmt.Sprintf(
+			"ST_DWithin(location, ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography, %s * 1000)",
+			arg(*filter.Longitude), arg(*filter.Latitude), arg(filter.Radius)))
+	case filter.MinLat != nil && filter.MinLon != nil && filter.MaxLat != nil && filter.MaxLon != nil:
+		conditions = append(conditions, fmt.Sprintf(
+			"ST_Contains(ST_MakeEnvelope(%s, %s, %s, %s, 4326), location::geometry)",
+			arg(*filter.MinLon), arg(*filter.MinLat), arg(*filter.MaxLon), arg(*filter.MaxLat)))
+	}
+	if !filter.Start.IsZero() {
+		conditions = append(conditions, "time >= "+arg(filter.Start))
+	}
+	if !filter.End.IsZero() {
+		conditions = append(conditions, "time <= "+arg(filter.End))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if err := r.Db.QueryRow(`SELECT COUNT(*) FROM anomalies `+where, args...).Scan(&page.Total); err != nil {
+		log.Printf("Error counting anomalies: %v", err)
+		return page, err
+	}
+
+	column := "time"
+	if filter.SortBy == SortByValue {
+		column = "value"
+	}
+	direction, comparison := "DESC", "<"
+	if filter.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+		var key interface{}
+		if column == "value" {
+			key, err = strconv.ParseFloat(c.Value, 64)
+		} else {
+			key, err = time.Parse(time.RFC3339Nano, c.Value)
+		}
+		if err != nil {
+			return page, ErrInvalidCursor
+		}
+		condition := fmt.Sprintf("(%s, id) %s (%s, %s)", column, comparison, arg(key), arg(c.ID))
+		if where == "" {
+			where = "WHERE " + condition
+		} else {
+			where += " AND " + condition
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM anomalies
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %s;`, anomalyColumns, where, column, direction, direction, arg(filter.Limit+1))
+
+	rows, err := r.Db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error listing anomalies: %v", err)
+		return page, err
+	}
+	defer rows.Close()
+
+	var last cursor
+	for rows.Next() {
+		anomaly, anomalyTime, err := scanAnomalyTime(rows)
+		if err != nil {
+			log.Printf("Error scanning anomaly row: %v", err)
+			return page, err
+		}
+
+		if len(page.Items) == filter.Limit {
+			// The extra row only tells us there is another page.
+			page.NextCursor = encodeCursor(last)
+			break
+		}
+		page.Items = append(page.Items, anomaly)
+
+		last = cursor{ID: anomaly.ID, Value: anomalyTime.Format(time.RFC3339Nano)}
+		if column == "value" {
+			last.Value = strconv.FormatFloat(anomaly.Value, 'g', -1, 64)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating anomaly rows: %v", err)
+		return page, err
+	}
+
+	return page, nil
+}
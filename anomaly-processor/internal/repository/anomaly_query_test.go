@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor cursor
+	}{
+		{"time key", cursor{Value: "2024-01-15T10:30:00.123456789Z", ID: 42}},
+		{"value key", cursor{Value: "187.25", ID: 7}},
+		{"negative value", cursor{Value: "-1e-05", ID: 1}},
+		{"zero id", cursor{Value: "2024-01-15T10:30:00Z"}},
+		{"key with json and url characters", cursor{Value: `a"b/c+d=e`, ID: 9007199254740993}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeCursor(tt.cursor)
+			if _, err := base64.RawURLEncoding.DecodeString(encoded); err != nil {
+				t.Fatalf("cursor %q is not URL-safe base64: %v", encoded, err)
+			}
+			decoded, err := decodeCursor(encoded)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q) returned error: %v", encoded, err)
+			}
+			if decoded != tt.cursor {
+				t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", tt.cursor, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not base64", "%%%"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"v":"x","id":1}`))},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("page 2"))},
+		{"wrong id type", base64.RawURLEncoding.EncodeToString([]byte(`{"v":"x","id":"1"}`))},
+		{"truncated", encodeCursor(cursor{Value: "187.25", ID: 7})[:10]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.value); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.value, err)
+			}
+		})
+	}
+}
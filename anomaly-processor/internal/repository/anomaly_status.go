@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"api/internal/models"
+	"api/internal/workflow"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const EventStatusChange = "status_change"
+
+var (
+	ErrAnomalyNotFound   = errors.New("anomaly not found")
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// UpdateAnomalyStatus applies a status change and records it in the
+// anomaly's history in one transaction. The row is locked while the
+// transition is checked, so concurrent changes are applied one after the
+// other. acknowledged_at is set the first time the anomaly leaves open and
+// resolved_at whenever it is closed; reopening clears resolved_at.
+func (r *AnomalyRepository) UpdateAnomalyStatus(id int64, change models.StatusChange) (models.Anomaly, error) {
+	tx, err := r.Db.Begin()
+	if err != nil {
+		return models.Anomaly{}, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(`SELECT status FROM anomalies WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Anomaly{}, ErrAnomalyNotFound
+	}
+	if err != nil {
+		return models.Anomaly{}, fmt.Errorf("error loading anomaly %d: %w", id, err)
+	}
+	if !workflow.CanTransition(current, change.Status) {
+		return models.Anomaly{}, fmt.Errorf("%w from %s to %s", ErrInvalidTransition, current, change.Status)
+	}
+
+	anomaly, err := scanAnomaly(tx.QueryRow(`
+		UPDATE anomalies
+		SET status            = $2,
+		    assignee          = CASE WHEN $3::boolean THEN NULLIF($4, '') ELSE assignee END,
+		    notes             = CASE WHEN $5::boolean THEN NULLIF($6, '') ELSE notes END,
+		    status_updated_at = now(),
+		    acknowledged_at   = CASE WHEN $2 <> $7 THEN COALESCE(acknowledged_at, now()) ELSE acknowledged_at END,
+		    resolved_at       = CASE WHEN $8::boolean THEN COALESCE(resolved_at, now()) END
+		WHERE id = $1
+		RETURNING `+anomalyColumns,
+		id, change.Status, change.Assignee != nil, stringValue(change.Assignee), change.Notes != nil, stringValue(change.Notes),
+		workflow.StatusOpen, workflow.Closed(change.Status)))
+	if err != nil {
+		return anomaly, fmt.Errorf("error updating anomaly %d: %w", id, err)
+	}
+
+	event := models.AnomalyEvent{
+		Event:      EventStatusChange,
+		FromStatus: current,
+		ToStatus:   change.Status,
+		Actor:      change.Actor,
+		Assignee:   stringValue(change.Assignee),
+		Note:       stringValue(change.Notes),
+	}
+	if err := addEvent(tx, id, event); err != nil {
+		return anomaly, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return anomaly, err
+	}
+	return anomaly, nil
+}
+
+// GetAnomalyHistory returns the anomaly's history, oldest first.
+func (r *AnomalyRepository) GetAnomalyHistory(id int64) ([]models.AnomalyEvent, error) {
+	rows, err := r.Db.Query(`
+		SELECT id, time, event,
+			   COALESCE(from_status, ''),
+			   COALESCE(to_status, ''),
+			   COALESCE(actor, ''),
+			   COALESCE(assignee, ''),
+			   COALESCE(note, '')
+		FROM anomaly_history
+		WHERE anomaly_id = $1
+		ORDER BY time, id;`, id)
+	if err != nil {
+		log.Printf("Error querying anomaly history: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.AnomalyEvent{}
+	for rows.Next() {
+		var event models.AnomalyEvent
+		if err := rows.Scan(&event.ID, &event.Time, &event.Event, &event.FromStatus, &event.ToStatus, &event.Actor, &event.Assignee, &event.Note); err != nil {
+			log.Printf("Error scanning anomaly history row: %v", err)
+			return nil, err
+		}
+		history = append(history, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating anomaly history rows: %v", err)
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func addEvent(tx *sql.Tx, anomalyID int64, event models.AnomalyEvent) error {
+	_, err := tx.Exec(`
+		INSERT INTO anomaly_history (anomaly_id, event, from_status, to_status, actor, assignee, note)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))`,
+		anomalyID, event.Event, event.FromStatus, event.ToStatus, event.Actor, event.Assignee, event.Note)
+	if err != nil {
+		return fmt.Errorf("error recording history of anomaly %d: %w", anomalyID, err)
+	}
+	return nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
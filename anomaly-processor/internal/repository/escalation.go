@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"api/internal/models"
+	"api/internal/workflow"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const EventEscalation = "escalation"
+
+// PendingEscalations returns the open anomalies of the given severities
+// detected since the given time, without locking them; ClaimEscalation
+// claims one before it is escalated.
+func (r *AnomalyRepository) PendingEscalations(severities []string, since time.Time) ([]models.Anomaly, error) {
+	rows, err := r.Db.Query(`
+		SELECT `+anomalyColumns+`
+		FROM anomalies
+		WHERE status = $1 AND severity = ANY($2) AND detected_at >= $3
+		ORDER BY detected_at`, workflow.StatusOpen, pq.Array(severities), since)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pending escalations: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []models.Anomaly
+	for rows.Next() {
+		anomaly, err := scanAnomaly(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning pending escalation: %w", err)
+		}
+		candidates = append(candidates, anomaly)
+	}
+	return candidates, rows.Err()
+}
+
+// ClaimEscalation claims the next escalation step of an anomaly that is
+// still open and still at the given level, and reloads it. The claim is a
+// lease committed right away, so no lock is held while the step is sent;
+// a step whose result is never recorded, because sending failed or the
+// instance crashed, becomes due again when the lease ends. The second
+// result is false when the anomaly was acknowledged, escalated or claimed
+// by another instance in the meantime.
+func (r *AnomalyRepository) ClaimEscalation(id int64, level int, lease time.Duration) (models.Anomaly, bool, error) {
+	anomaly, err := scanAnomaly(r.Db.QueryRow(`
+		UPDATE anomalies
+		SET escalation_claimed_until = now() + make_interval(secs => $4)
+		WHERE id = $1 AND status = $2 AND escalation_level = $3
+		  AND (escalation_claimed_until IS NULL OR escalation_claimed_until <= now())
+		RETURNING `+anomalyColumns, id, workflow.StatusOpen, level, lease.Seconds()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return anomaly, false, nil
+	}
+	if err != nil {
+		return anomaly, false, fmt.Errorf("error claiming escalation of anomaly %d: %w", id, err)
+	}
+	return anomaly, true, nil
+}
+
+// RecordEscalation stores the anomaly's new escalation level once the step
+// was sent, ends the claim and adds the escalation to its history.
+func (r *AnomalyRepository) RecordEscalation(id int64, level int, target string) error {
+	tx, err := r.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE anomalies
+		SET escalation_level = $2, escalated_at = now(), escalation_claimed_until = NULL
+		WHERE id = $1`, id, level)
+	if err != nil {
+		return fmt.Errorf("error updating escalation of anomaly %d: %w", id, err)
+	}
+
+	err = addEvent(tx, id, models.AnomalyEvent{
+		Event: EventEscalation,
+		Actor: "escalation",
+		Note:  fmt.Sprintf("level %d: notified %s", level, target),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"api/internal/aqi"
+	"api/internal/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MeasurementQuery selects readings for a time series. At most one location
+// filter is used: a sensor, a point with a radius in kilometres, or a
+// bounding box.
+type MeasurementQuery struct {
+	Parameter string
+	SensorID  string
+
+	Latitude  *float64
+	Longitude *float64
+	Radius    float64
+
+	MinLat, MinLon, MaxLat, MaxLon *float64
+
+	Start  time.Time
+	End    time.Time
+	Bucket time.Duration
+}
+
+type MeasurementRepository struct {
+	Db *sql.DB
+}
+
+func NewMeasurementRepository(db *sql.DB) *MeasurementRepository {
+	return &MeasurementRepository{Db: db}
+}
+
+// GetMeasurementSeries aggregates readings into time_bucket intervals, one
+// row per bucket and parameter, ordered by time.
+func (r *MeasurementRepository) GetMeasurementSeries(q MeasurementQuery) ([]models.MeasurementBucket, error) {
+	args := []interface{}{q.Bucket.Seconds(), q.Start, q.End}
+	conditions := []string{"time >= $2", "time < $3"}
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.Parameter != "" {
+		conditions = append(conditions, "parameter = "+arg(q.Parameter))
+	}
+	switch {
+	case q.SensorID != "":
+		conditions = append(conditions, "sensor_id = "+arg(q.SensorID))
+	case q.Latitude != nil && q.Longitude != nil:
+		conditions = append(conditions, fmt.Sprintf(
+			"ST_DWithin(location, ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography, %s * 1000)",
+			arg(*q.Longitude), arg(*q.Latitude), arg(q.Radius)))
+	case q.MinLat != nil && q.MinLon != nil && q.MaxLat != nil && q.MaxLon != nil:
+		conditions = append(conditions, fmt.Sprintf(
+			"ST_Contains(ST_MakeEnvelope(%s, %s, %s, %s, 4326), location::geometry)",
+			arg(*q.MinLon), arg(*q.MinLat), arg(*q.MaxLon), arg(*q.MaxLat)))
+	}
+
+	query := `
+		SELECT time_bucket(make_interval(secs => $1), time) AS bucket,
+			   parameter,
+			   AVG(value),
+			   MIN(value),
+			   MAX(value),
+			   percentile_cont(0.95) WITHIN GROUP (ORDER BY value),
+			   COUNT(*)
+		FROM measurements
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY bucket, parameter
+		ORDER BY bucket, parameter;`
+
+	rows, err := r.Db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error querying measurement series: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []models.MeasurementBucket{}
+	for rows.Next() {
+		var bucket models.MeasurementBucket
+		if err := rows.Scan(&bucket.Time, &bucket.Parameter, &bucket.Avg, &bucket.Min, &bucket.Max, &bucket.P95, &bucket.Count); err != nil {
+			log.Printf("Error scanning measurement row: %v", err)
+			return nil, err
+		}
+		series = append(series, bucket)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating measurement rows: %v", err)
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// GetLatestReadings returns the most recent reading per parameter for every
+// sensor (or, without a sensor ID, every coordinate) inside the bounding box
+// that reported since the given time, grouped by location.
+func (r *MeasurementRepository) GetLatestReadings(minLat, minLon, maxLat, maxLon float64, parameter string, since time.Time) ([]models.LocationSnapshot, error) {
+	query := `
+		SELECT DISTINCT ON (COALESCE(sensor_id, ST_AsText(location::geometry)), parameter)
+			   COALESCE(sensor_id, ''),
+			   ST_Y(location::geometry) AS latitude,
+			   ST_X(location::geometry) AS longitude,
+			   parameter,
+			   value,
+			   time
+		FROM measurements
+		WHERE time >= $5
+		  AND ST_Contains(ST_MakeEnvelope($1, $2, $3, $4, 4326), location::geometry)
+		  AND ($6 = '' OR parameter = $6)
+		ORDER BY COALESCE(sensor_id, ST_AsText(location::geometry)), parameter, time DESC;`
+
+	rows, err := r.Db.Query(query, minLon, minLat, maxLon, maxLat, since, parameter)
+	if err != nil {
+		log.Printf("Error querying latest readings: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []models.LocationSnapshot{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var sensorID string
+		var latitude, longitude float64
+		var reading models.LatestReading
+		if err := rows.Scan(&sensorID, &latitude, &longitude, &reading.Parameter, &reading.Value, &reading.Time); err != nil {
+			log.Printf("Error scanning latest reading row: %v", err)
+			return nil, err
+		}
+		reading.Category = aqi.Category(reading.Parameter, reading.Value)
+
+		key := sensorID
+		if key == "" {
+			key = fmt.Sprintf("%f,%f", latitude, longitude)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(snapshots)
+			index[key] = i
+			snapshots = append(snapshots, models.LocationSnapshot{SensorID: sensorID})
+		}
+
+		snapshot := &snapshots[i]
+		if reading.Time.After(snapshot.Time) {
+			snapshot.Time = reading.Time
+			snapshot.Latitude, snapshot.Longitude = latitude, longitude
+		}
+		snapshot.Category = aqi.Worse(snapshot.Category, reading.Category)
+		snapshot.Readings = append(snapshot.Readings, reading)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating latest reading rows: %v", err)
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// GetReadingsAround returns every reading taken within radius metres of the
+// coordinates between from and to, ordered by time.
+func (r *MeasurementRepository) GetReadingsAround(latitude, longitude, radius float64, from, to time.Time) ([]models.Reading, error) {
+	query := `
+		SELECT COALESCE(sensor_id, ''), parameter, value, time
+		FROM measurements
+		WHERE time >= $4 AND time <= $5
+		  AND ST_DWithin(location, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3)
+		ORDER BY time, parameter;`
+
+	rows, err := r.Db.Query(query, longitude, latitude, radius, from, to)
+	if err != nil {
+		log.Printf("Error querying readings around location: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	readings := []models.Reading{}
+	for rows.Next() {
+		var reading models.Reading
+		if err := rows.Scan(&reading.SensorID, &reading.Parameter, &reading.Value, &reading.Time); err != nil {
+			log.Printf("Error scanning reading row: %v", err)
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating reading rows: %v", err)
+		return nil, err
+	}
+
+	return readings, nil
+}
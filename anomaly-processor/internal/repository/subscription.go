@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"api/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidArea     = errors.New("invalid area")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// severityOrder lists the anomaly severities from least to most severe.
+var severityOrder = []string{"low", "high", "critical"}
+
+// SubscriptionMatch is a subscription that is to be notified about an
+// anomaly.
+type SubscriptionMatch struct {
+	SubscriptionID int64
+	Channel        string
+}
+
+type SubscriptionRepository struct {
+	Db *sql.DB
+}
+
+func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
+	return &SubscriptionRepository{Db: db}
+}
+
+const subscriptionColumns = `
+			   id, user_id, COALESCE(name, ''), ST_AsGeoJSON(area::geometry),
+			   ST_Y(center::geometry), ST_X(center::geometry), radius_km,
+			   parameters, min_severity,
+			   left(quiet_start::text, 5), left(quiet_end::text, 5), timezone,
+			   channel, enabled, created_at`
+
+func scanSubscription(row scanner) (models.Subscription, error) {
+	var s models.Subscription
+	var area, quietStart, quietEnd sql.NullString
+	var latitude, longitude, radius sql.NullFloat64
+	var timezone string
+	err := row.Scan(&s.ID, &s.UserID, &s.Name, &area,
+		&latitude, &longitude, &radius,
+		pq.Array(&s.Parameters), &s.MinSeverity,
+		&quietStart, &quietEnd, &timezone,
+		&s.Channel, &s.Enabled, &s.CreatedAt)
+	if err != nil {
+		return s, err
+	}
+	if area.Valid {
+		s.Area = []byte(area.String)
+	}
+	if latitude.Valid && longitude.Valid {
+		s.Center = &models.Point{Latitude: latitude.Float64, Longitude: longitude.Float64}
+		s.Radius = radius.Float64
+	}
+	if quietStart.Valid && quietEnd.Valid {
+		s.QuietHours = &models.QuietHours{Start: quietStart.String, End: quietEnd.String, Timezone: timezone}
+	}
+	if s.Parameters == nil {
+		s.Parameters = []string{}
+	}
+	return s, nil
+}
+
+// CreateSubscription stores a subscription. It returns ErrInvalidArea when
+// PostGIS cannot read the area or the polygon is not valid, and
+// ErrInvalidTimezone for a quiet hours timezone PostgreSQL does not know.
+func (r *SubscriptionRepository) CreateSubscription(s models.Subscription) (models.Subscription, error) {
+	var area *string
+	if len(s.Area) > 0 {
+		value := string(s.Area)
+		area = &value
+
+		var valid bool
+		err := r.Db.QueryRow(`SELECT ST_IsValid(ST_GeomFromGeoJSON($1::text))`, value).Scan(&valid)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) || (err == nil && !valid) {
+			return s, ErrInvalidArea
+		}
+		if err != nil {
+			return s, fmt.Errorf("error validating subscription area: %w", err)
+		}
+	}
+
+	var latitude, longitude, radius *float64
+	if s.Center != nil {
+		latitude, longitude, radius = &s.Center.Latitude, &s.Center.Longitude, &s.Radius
+	}
+
+	var quietStart, quietEnd *string
+	timezone := "UTC"
+	if s.QuietHours != nil {
+		quietStart, quietEnd = &s.QuietHours.Start, &s.QuietHours.End
+		timezone = s.QuietHours.Timezone
+
+		var known bool
+		err := r.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = $1)`, timezone).Scan(&known)
+		if err != nil {
+			return s, fmt.Errorf("error validating timezone: %w", err)
+		}
+		if !known {
+			return s, ErrInvalidTimezone
+		}
+	}
+
+	created, err := scanSubscription(r.Db.QueryRow(`
+		INSERT INTO subscriptions (user_id, name, area, center, radius_km, parameters, min_severity,
+		                           quiet_start, quiet_end, timezone, channel)
+		VALUES ($1, NULLIF($2, ''),
+		        ST_SetSRID(ST_GeomFromGeoJSON($3::text), 4326)::geography,
+		        CASE WHEN $4::float8 IS NULL THEN NULL ELSE ST_SetSRID(ST_MakePoint($5, $4), 4326)::geography END,
+		        $6, $7, $8, $9::time, $10::time, $11, $12)
+		RETURNING `+subscriptionColumns,
+		s.UserID, s.Name, area, latitude, longitude, radius, pq.Array(s.Parameters), s.MinSeverity,
+		quietStart, quietEnd, timezone, s.Channel))
+	if err != nil {
+		return created, fmt.Errorf("error creating subscription: %w", err)
+	}
+	return created, nil
+}
+
+// ListSubscriptions returns the subscriptions of a user, or of every user
+// when userID is empty.
+func (r *SubscriptionRepository) ListSubscriptions(userID string) ([]models.Subscription, error) {
+	rows, err := r.Db.Query(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE $1 = '' OR user_id = $1
+		ORDER BY id`, userID)
+	if err != nil {
+		log.Printf("Error querying subscriptions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			log.Printf("Error scanning subscription row: %v", err)
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating subscription rows: %v", err)
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// GetSubscription loads one subscription. The second result is false when
+// there is no subscription with that ID.
+func (r *SubscriptionRepository) GetSubscription(id int64) (models.Subscription, bool, error) {
+	s, err := scanSubscription(r.Db.QueryRow(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, false, nil
+	}
+	if err != nil {
+		log.Printf("Error querying subscription %d: %v", id, err)
+		return s, false, err
+	}
+	return s, true, nil
+}
+
+func (r *SubscriptionRepository) DeleteSubscription(id int64) (bool, error) {
+	result, err := r.Db.Exec(`DELETE FROM subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting subscription %d: %w", id, err)
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// MatchSubscriptions finds the enabled subscriptions an anomaly is routed
+// to and records them in subscription_notifications: the anomaly lies in
+// the subscription's polygon or within its radius, its parameter is one of
+// the subscription's, its severity is at least the minimum and the
+// subscription is outside its quiet hours. Subscriptions already recorded
+// for the anomaly are not returned again.
+func (r *SubscriptionRepository) MatchSubscriptions(anomalyID int64) ([]SubscriptionMatch, error) {
+	rows, err := r.Db.Query(`
+		WITH matched AS (
+			SELECT s.id, s.channel
+			FROM subscriptions s
+			JOIN anomalies a ON a.id = $1
+			CROSS JOIN LATERAL (SELECT (now() AT TIME ZONE s.timezone)::time AS local_time) l
+			WHERE s.enabled
+			  AND (ST_Intersects(s.area, a.location) OR ST_DWithin(s.center, a.location, s.radius_km * 1000))
+			  AND (cardinality(s.parameters) = 0 OR upper(a.parameter) = ANY(s.parameters))
+			  AND COALESCE(array_position($2::text[], a.severity), 1) >= array_position($2::text[], s.min_severity)
+			  AND NOT COALESCE(CASE
+			      WHEN s.quiet_start <= s.quiet_end THEN l.local_time >= s.quiet_start AND l.local_time < s.quiet_end
+			      ELSE l.local_time >= s.quiet_start OR l.local_time < s.quiet_end
+			  END, FALSE)
+		), recorded AS (
+			INSERT INTO subscription_notifications (subscription_id, anomaly_id)
+			SELECT id, $1 FROM matched
+			ON CONFLICT (subscription_id, anomaly_id) DO NOTHING
+			RETURNING subscription_id
+		)
+		SELECT m.id, m.channel
+		FROM matched m
+		JOIN recorded r ON r.subscription_id = m.id
+		ORDER BY m.id`, anomalyID, pq.Array(severityOrder))
+	if err != nil {
+		return nil, fmt.Errorf("error matching subscriptions for anomaly %d: %w", anomalyID, err)
+	}
+	defer rows.Close()
+
+	var matches []SubscriptionMatch
+	for rows.Next() {
+		var match SubscriptionMatch
+		if err := rows.Scan(&match.SubscriptionID, &match.Channel); err != nil {
+			return nil, fmt.Errorf("error scanning subscription match: %w", err)
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// ReleaseSubscriptionNotification forgets that a subscription was notified
+// about an anomaly, so that the next match sends it again.
+func (r *SubscriptionRepository) ReleaseSubscriptionNotification(subscriptionID, anomalyID int64) error {
+	_, err := r.Db.Exec(`
+		DELETE FROM subscription_notifications
+		WHERE subscription_id = $1 AND anomaly_id = $2`, subscriptionID, anomalyID)
+	if err != nil {
+		return fmt.Errorf("error releasing notification of subscription %d: %w", subscriptionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"api/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// PendingDelivery is a delivery that is due, with what is needed to send it.
+type PendingDelivery struct {
+	models.WebhookDelivery
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+type WebhookRepository struct {
+	Db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{Db: db}
+}
+
+const webhookColumns = `
+			   id, url, format, COALESCE(description, ''), parameters, severities,
+			   min_lat, min_lon, max_lat, max_lon,
+			   enabled, consecutive_failures, disabled_at, created_at`
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var webhook models.Webhook
+	var minLat, minLon, maxLat, maxLon sql.NullFloat64
+	var disabledAt sql.NullTime
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Format, &webhook.Description, pq.Array(&webhook.Parameters), pq.Array(&webhook.Severities),
+		&minLat, &minLon, &maxLat, &maxLon,
+		&webhook.Enabled, &webhook.ConsecutiveFailures, &disabledAt, &webhook.CreatedAt)
+	if err != nil {
+		return webhook, err
+	}
+	if minLat.Valid && minLon.Valid && maxLat.Valid && maxLon.Valid {
+		webhook.Region = &models.BoundingBox{MinLat: minLat.Float64, MinLon: minLon.Float64, MaxLat: maxLat.Float64, MaxLon: maxLon.Float64}
+	}
+	if webhook.Parameters == nil {
+		webhook.Parameters = []string{}
+	}
+	if webhook.Severities == nil {
+		webhook.Severities = []string{}
+	}
+	webhook.DisabledAt = timePointer(disabledAt)
+	return webhook, nil
+}
+
+func (r *WebhookRepository) CreateWebhook(webhook models.Webhook) (models.Webhook, error) {
+	var minLat, minLon, maxLat, maxLon *float64
+	if webhook.Region != nil {
+		minLat, minLon, maxLat, maxLon = &webhook.Region.MinLat, &webhook.Region.MinLon, &webhook.Region.MaxLat, &webhook.Region.MaxLon
+	}
+
+	created, err := scanWebhook(r.Db.QueryRow(`
+		INSERT INTO webhooks (url, secret, format, description, parameters, severities, min_lat, min_lon, max_lat, max_lon)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10)
+		RETURNING `+webhookColumns,
+		webhook.URL, webhook.Secret, webhook.Format, webhook.Description, pq.Array(webhook.Parameters), pq.Array(webhook.Severities),
+		minLat, minLon, maxLat, maxLon))
+	if err != nil {
+		return created, fmt.Errorf("error creating webhook: %w", err)
+	}
+	created.Secret = webhook.Secret
+	return created, nil
+}
+
+func (r *WebhookRepository) ListWebhooks() ([]models.Webhook, error) {
+	rows, err := r.Db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		log.Printf("Error querying webhooks: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			log.Printf("Error scanning webhook row: %v", err)
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating webhook rows: %v", err)
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhook loads one webhook. The second result is false when there is no
+// webhook with that ID.
+func (r *WebhookRepository) GetWebhook(id int64) (models.Webhook, bool, error) {
+	webhook, err := scanWebhook(r.Db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook, false, nil
+	}
+	if err != nil {
+		log.Printf("Error querying webhook %d: %v", id, err)
+		return webhook, false, err
+	}
+	return webhook, true, nil
+}
+
+// DeleteWebhook removes the webhook together with its delivery log.
+func (r *WebhookRepository) DeleteWebhook(id int64) (bool, error) {
+	result, err := r.Db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook %d: %w", id, err)
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// EnableWebhook turns a disabled webhook back on and resets its failure
+// count.
+func (r *WebhookRepository) EnableWebhook(id int64) (models.Webhook, bool, error) {
+	webhook, err := scanWebhook(r.Db.QueryRow(`
+		UPDATE webhooks
+		SET enabled = TRUE, consecutive_failures = 0, disabled_at = NULL
+		WHERE id = $1
+		RETURNING `+webhookColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook, false, nil
+	}
+	if err != nil {
+		return webhook, false, fmt.Errorf("error enabling webhook %d: %w", id, err)
+	}
+	return webhook, true, nil
+}
+
+// EnqueueMatching adds a delivery of the payload to every enabled webhook
+// with the given format whose filters match the anomaly. The key makes this
+// idempotent: a webhook gets at most one delivery per key.
+func (r *WebhookRepository) EnqueueMatching(anomaly models.Anomaly, format, event, key string, payload []byte) (int64, error) {
+	result, err := r.Db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, anomaly_id, event, delivery_key, payload)
+		SELECT id, $1, $2, $3, $4
+		FROM webhooks
+		WHERE enabled AND format = $9
+		  AND (cardinality(parameters) = 0 OR upper($5) = ANY(parameters))
+		  AND (cardinality(severities) = 0 OR $6 = ANY(severities))
+		  AND (min_lat IS NULL OR ($7 BETWEEN min_lat AND max_lat AND $8 BETWEEN min_lon AND max_lon))
+		ON CONFLICT (webhook_id, delivery_key) DO NOTHING`,
+		anomaly.ID, event, key, payload, anomaly.Parameter, anomaly.Severity, anomaly.Latitude, anomaly.Longitude, format)
+	if err != nil {
+		return 0, fmt.Errorf("error enqueueing webhook deliveries for anomaly %d: %w", anomaly.ID, err)
+	}
+	return result.RowsAffected()
+}
+
+// EnqueueDelivery adds a delivery to one webhook regardless of its filters.
+func (r *WebhookRepository) EnqueueDelivery(webhookID, anomalyID int64, event, key string, payload []byte) error {
+	result, err := r.Db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, anomaly_id, event, delivery_key, payload)
+		SELECT id, $2, $3, $4, $5
+		FROM webhooks
+		WHERE id = $1 AND enabled
+		ON CONFLICT (webhook_id, delivery_key) DO NOTHING`,
+		webhookID, anomalyID, event, key, payload)
+	if err != nil {
+		return fmt.Errorf("error enqueueing delivery to webhook %d: %w", webhookID, err)
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		// Nothing was added for a disabled webhook or a repeated key; only
+		// a missing webhook is an error.
+		if _, found, err := r.GetWebhook(webhookID); err == nil && !found {
+			return ErrWebhookNotFound
+		}
+	}
+	return nil
+}
+
+// ClaimDueDeliveries claims up to limit pending deliveries of enabled
+// webhooks whose next attempt is due, oldest first, by moving their next
+// attempt lease into the future. The claim is committed right away, so no
+// locks are held while the deliveries are sent; a delivery whose result is
+// never recorded, e.g. after a crash, becomes due again when the lease ends.
+// Rows locked by another instance are skipped.
+func (r *WebhookRepository) ClaimDueDeliveries(limit int, lease time.Duration) ([]PendingDelivery, error) {
+	rows, err := r.Db.Query(`
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = now() + make_interval(secs => $3)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id
+		  AND d.id IN (
+			SELECT due.id
+			FROM webhook_deliveries due
+			JOIN webhooks hook ON hook.id = due.webhook_id
+			WHERE due.status = $1 AND due.next_attempt_at <= now() AND hook.enabled
+			ORDER BY due.next_attempt_at, due.id
+			LIMIT $2
+			FOR UPDATE OF due SKIP LOCKED)
+		RETURNING d.id, d.webhook_id, d.anomaly_id, d.event, d.status, d.attempts, d.created_at,
+				  w.url, w.secret, d.payload`, DeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []PendingDelivery
+	for rows.Next() {
+		var d PendingDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.AnomalyID, &d.Event, &d.Status, &d.Attempts, &d.CreatedAt,
+			&d.URL, &d.Secret, &d.Payload); err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the subquery's order.
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// MarkDelivered records a successful attempt and resets the webhook's
+// failure count.
+func (r *WebhookRepository) MarkDelivered(delivery PendingDelivery, statusCode int) error {
+	tx, err := r.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = NULL,
+		    delivered_at = now(), next_attempt_at = NULL
+		WHERE id = $1`, delivery.ID, DeliveryDelivered, statusCode)
+	if err != nil {
+		return fmt.Errorf("error marking webhook delivery %d delivered: %w", delivery.ID, err)
+	}
+	_, err = tx.Exec(`UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1`, delivery.WebhookID)
+	if err != nil {
+		return fmt.Errorf("error resetting failures of webhook %d: %w", delivery.WebhookID, err)
+	}
+	return tx.Commit()
+}
+
+// MarkAttemptFailed records a failed attempt. The delivery is retried at
+// retryAt, or given up when retryAt is nil. Once the webhook has failed
+// disableAfter times in a row it is disabled and its pending deliveries are
+// given up; the result reports whether that happened.
+func (r *WebhookRepository) MarkAttemptFailed(delivery PendingDelivery, statusCode int, message string, retryAt *time.Time, disableAfter int) (bool, error) {
+	tx, err := r.Db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status := DeliveryPending
+	if retryAt == nil {
+		status = DeliveryFailed
+	}
+	_, err = tx.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_status_code = NULLIF($3, 0), last_error = $4,
+		    next_attempt_at = $5
+		WHERE id = $1`, delivery.ID, status, statusCode, message, retryAt)
+	if err != nil {
+		return false, fmt.Errorf("error recording failed webhook delivery %d: %w", delivery.ID, err)
+	}
+
+	var disabled bool
+	err = tx.QueryRow(`
+		UPDATE webhooks
+		SET consecutive_failures = consecutive_failures + 1,
+		    enabled     = consecutive_failures + 1 < $2,
+		    disabled_at = CASE WHEN consecutive_failures + 1 >= $2 THEN now() ELSE disabled_at END
+		WHERE id = $1
+		RETURNING NOT enabled`, delivery.WebhookID, disableAfter).Scan(&disabled)
+	if err != nil {
+		return false, fmt.Errorf("error recording failure of webhook %d: %w", delivery.WebhookID, err)
+	}
+
+	if disabled {
+		_, err = tx.Exec(`
+			UPDATE webhook_deliveries
+			SET status = $2, last_error = 'webhook disabled', next_attempt_at = NULL
+			WHERE webhook_id = $1 AND status = $3 AND id <> $4`, delivery.WebhookID, DeliveryFailed, DeliveryPending, delivery.ID)
+		if err != nil {
+			return false, fmt.Errorf("error giving up deliveries of webhook %d: %w", delivery.WebhookID, err)
+		}
+	}
+	return disabled, tx.Commit()
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest
+// first.
+func (r *WebhookRepository) ListDeliveries(webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := r.Db.Query(`
+		SELECT id, webhook_id, anomaly_id, event, status, attempts,
+			   COALESCE(last_status_code, 0), COALESCE(last_error, ''),
+			   next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2;`, webhookID, limit)
+	if err != nil {
+		log.Printf("Error querying webhook deliveries: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var nextAttemptAt, deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.AnomalyID, &d.Event, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &nextAttemptAt, &d.CreatedAt, &deliveredAt); err != nil {
+			log.Printf("Error scanning webhook delivery row: %v", err)
+			return nil, err
+		}
+		d.NextAttemptAt = timePointer(nextAttemptAt)
+		d.DeliveredAt = timePointer(deliveredAt)
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating webhook delivery rows: %v", err)
+		return nil, err
+	}
+
+	return deliveries, nil
+}
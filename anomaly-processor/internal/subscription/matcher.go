@@ -0,0 +1,52 @@
+package subscription
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"api/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Matcher is a notify.Notifier that routes every new anomaly to the
+// subscriptions it matches, using PostGIS to test the subscription areas,
+// and sends it to each subscription's channel through the dispatcher.
+type Matcher struct {
+	Subscriptions *repository.SubscriptionRepository
+	Dispatcher    *notify.Dispatcher
+}
+
+func NewMatcher(db *sql.DB, dispatcher *notify.Dispatcher) *Matcher {
+	return &Matcher{
+		Subscriptions: repository.NewSubscriptionRepository(db),
+		Dispatcher:    dispatcher,
+	}
+}
+
+func (m *Matcher) NotifyAnomaly(anomaly models.Anomaly) error {
+	matches, err := m.Subscriptions.MatchSubscriptions(anomaly.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, match := range matches {
+		notification := notify.Notification{Event: notify.EventSubscription, Anomaly: anomaly}
+		err := m.Dispatcher.Send(match.Channel, notification)
+		switch {
+		case errors.Is(err, notify.ErrInvalidRecipient):
+			// E.g. the webhook was deleted or email was turned off after
+			// the subscription was made; retrying would not help.
+			log.Printf("Subscription %d: %v", match.SubscriptionID, err)
+		case err != nil:
+			errs = append(errs, fmt.Errorf("subscription %d: %w", match.SubscriptionID, err))
+			// Forget the match so that the retried anomaly is sent again.
+			if err := m.Subscriptions.ReleaseSubscriptionNotification(match.SubscriptionID, anomaly.ID); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
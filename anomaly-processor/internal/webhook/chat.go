@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"api/internal/models"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Webhook formats. Slack and Teams webhooks are incoming webhook URLs of a
+// chat channel; they receive a formatted message instead of the JSON
+// payload.
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatTeams = "teams"
+)
+
+var Formats = []string{FormatJSON, FormatSlack, FormatTeams}
+
+var severityColors = map[string]string{
+	"critical": "B71C1C",
+	"high":     "E65100",
+}
+
+const defaultColor = "555555"
+
+// render returns the body posted to a webhook of the given format.
+func render(format string, payload Payload) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(slackMessage(payload))
+	case FormatTeams:
+		return json.Marshal(teamsMessage(payload))
+	}
+	return json.Marshal(payload)
+}
+
+type fact struct {
+	Name  string
+	Value string
+	Short bool
+}
+
+func title(payload Payload) string {
+	anomaly := payload.Anomaly
+	text := fmt.Sprintf("%s air quality anomaly: %s", strings.ToUpper(anomaly.Severity), anomaly.Parameter)
+	if payload.Level > 0 {
+		text = fmt.Sprintf("Escalation %d, not acknowledged: %s", payload.Level, text)
+	}
+	return text
+}
+
+func facts(anomaly models.Anomaly) []fact {
+	facts := []fact{{"Value", fmt.Sprintf("%.2f µg/m³", anomaly.Value), true}}
+	if anomaly.Threshold > 0 {
+		facts = append(facts, fact{"Threshold", fmt.Sprintf("%.0f µg/m³ (WHO guideline, %.1fx)", anomaly.Threshold, anomaly.Value/anomaly.Threshold), true})
+	}
+	facts = append(facts,
+		fact{"Severity", anomaly.Severity, true},
+		fact{"Status", anomaly.Status, true},
+		fact{"Location", fmt.Sprintf("%.4f, %.4f", anomaly.Latitude, anomaly.Longitude), true},
+		fact{"Time", anomaly.Time, true},
+		fact{"Detector", anomaly.Description, false},
+	)
+	return facts
+}
+
+func color(severity string) string {
+	if c, ok := severityColors[severity]; ok {
+		return c
+	}
+	return defaultColor
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link"`
+	Fields    []slackField `json:"fields"`
+	Footer    string       `json:"footer"`
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackMessage builds a message for a Slack incoming webhook: an attachment
+// coloured by severity whose title links to the map.
+func slackMessage(payload Payload) slackPayload {
+	anomaly := payload.Anomaly
+	var fields []slackField
+	for _, f := range facts(anomaly) {
+		fields = append(fields, slackField{Title: f.Name, Value: f.Value, Short: f.Short})
+	}
+	fields = append(fields, slackField{Title: "Map", Value: fmt.Sprintf("<%s|Open in OpenStreetMap>", anomaly.MapURL())})
+
+	text := title(payload)
+	return slackPayload{
+		Text: text,
+		Attachments: []slackAttachment{{
+			Fallback:  fmt.Sprintf("%s, %.2f µg/m³", text, anomaly.Value),
+			Color:     "#" + color(anomaly.Severity),
+			Title:     fmt.Sprintf("Anomaly #%d", anomaly.ID),
+			TitleLink: anomaly.MapURL(),
+			Fields:    fields,
+			Footer:    "Air Quality Monitoring",
+		}},
+	}
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type teamsSection struct {
+	ActivityTitle string      `json:"activityTitle"`
+	Facts         []teamsFact `json:"facts"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsPayload struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	ThemeColor string         `json:"themeColor"`
+	Summary    string         `json:"summary"`
+	Title      string         `json:"title"`
+	Sections   []teamsSection `json:"sections"`
+	Actions    []teamsAction  `json:"potentialAction"`
+}
+
+// teamsMessage builds a MessageCard for a Microsoft Teams incoming webhook,
+// themed by severity with a button that opens the map.
+func teamsMessage(payload Payload) teamsPayload {
+	anomaly := payload.Anomaly
+	var teamsFacts []teamsFact
+	for _, f := range facts(anomaly) {
+		teamsFacts = append(teamsFacts, teamsFact{Name: f.Name, Value: f.Value})
+	}
+
+	text := title(payload)
+	return teamsPayload{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: color(anomaly.Severity),
+		Summary:    text,
+		Title:      text,
+		Sections: []teamsSection{{
+			ActivityTitle: fmt.Sprintf("Anomaly #%d", anomaly.ID),
+			Facts:         teamsFacts,
+		}},
+		Actions: []teamsAction{{
+			Type:    "OpenUri",
+			Name:    "Open in OpenStreetMap",
+			Targets: []teamsTarget{{OS: "default", URI: anomaly.MapURL()}},
+		}},
+	}
+}
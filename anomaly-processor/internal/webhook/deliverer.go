@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"api/internal/repository"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderSignature = "X-Signature"
+
+	defaultInterval     = 5 * time.Second
+	defaultBatchSize    = 20
+	defaultTimeout      = 10 * time.Second
+	defaultMaxAttempts  = 8
+	defaultDisableAfter = 20
+	initialBackoff      = 30 * time.Second
+	maxBackoff          = time.Hour
+)
+
+// Deliverer posts queued deliveries to their webhooks. A delivery that fails
+// (network error or non-2xx response) is retried with exponential backoff
+// starting at 30s and capped at one hour, and given up after MaxAttempts.
+// A webhook that fails DisableAfter attempts in a row is disabled.
+type Deliverer struct {
+	Db           *sql.DB
+	Client       *http.Client
+	Webhooks     *repository.WebhookRepository
+	Interval     time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	DisableAfter int
+}
+
+func NewDeliverer(db *sql.DB) *Deliverer {
+	return &Deliverer{
+		Db:           db,
+		Client:       &http.Client{Timeout: defaultTimeout},
+		Webhooks:     repository.NewWebhookRepository(db),
+		Interval:     defaultInterval,
+		BatchSize:    defaultBatchSize,
+		MaxAttempts:  defaultMaxAttempts,
+		DisableAfter: defaultDisableAfter,
+	}
+}
+
+// NewDelivererFromEnv reads WEBHOOK_TIMEOUT (default 10s),
+// WEBHOOK_MAX_ATTEMPTS (default 8) and WEBHOOK_DISABLE_AFTER (default 20).
+func NewDelivererFromEnv(db *sql.DB) (*Deliverer, error) {
+	d := NewDeliverer(db)
+	if value := os.Getenv("WEBHOOK_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT %q", value)
+		}
+		d.Client.Timeout = timeout
+	}
+	for name, target := range map[string]*int{"WEBHOOK_MAX_ATTEMPTS": &d.MaxAttempts, "WEBHOOK_DISABLE_AFTER": &d.DisableAfter} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = n
+		}
+	}
+	return d, nil
+}
+
+func (d *Deliverer) Start() {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			sent, err := d.deliverBatch()
+			if err != nil {
+				log.Printf("Webhook deliverer: %v", err)
+				break
+			}
+			if sent < d.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliverBatch claims due deliveries and posts them outside any
+// transaction, recording each result on its own. The claim lasts long
+// enough to post the whole batch, so another instance does not pick the
+// deliveries up in the meantime.
+func (d *Deliverer) deliverBatch() (int, error) {
+	lease := time.Duration(d.BatchSize)*d.Client.Timeout + time.Minute
+	deliveries, err := d.Webhooks.ClaimDueDeliveries(d.BatchSize, lease)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	disabled := make(map[int64]bool)
+	for _, delivery := range deliveries {
+		if disabled[delivery.WebhookID] {
+			// Given up together with the webhook's other pending deliveries.
+			continue
+		}
+
+		statusCode, err := d.post(delivery)
+		if err == nil {
+			if err := d.Webhooks.MarkDelivered(delivery, statusCode); err != nil {
+				// The delivery is sent again when the claim runs out.
+				log.Printf("Webhook deliverer: %v", err)
+			}
+			continue
+		}
+
+		var retryAt *time.Time
+		attempts := delivery.Attempts + 1
+		if attempts < d.MaxAttempts {
+			next := time.Now().Add(Backoff(attempts))
+			retryAt = &next
+		}
+		log.Printf("Webhook %d delivery %d attempt %d failed: %v", delivery.WebhookID, delivery.ID, attempts, err)
+
+		off, markErr := d.Webhooks.MarkAttemptFailed(delivery, statusCode, err.Error(), retryAt, d.DisableAfter)
+		if markErr != nil {
+			log.Printf("Webhook deliverer: %v", markErr)
+			continue
+		}
+		if off {
+			disabled[delivery.WebhookID] = true
+			log.Printf("Webhook %d disabled after %d consecutive failures", delivery.WebhookID, d.DisableAfter)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// post sends one delivery and returns the response status code.
+func (d *Deliverer) post(delivery repository.PendingDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "air-quality-anomaly-processor")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "hmac-sha256="+Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and the body separated
+// by a newline.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait before the next attempt after the given number of
+// failed attempts: 30s, 1m, 2m, ... up to one hour.
+func Backoff(attempts int) time.Duration {
+	wait := initialBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
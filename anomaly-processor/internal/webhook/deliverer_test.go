@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"anomaly.created"}`)
+	signature := Sign("secret", "1705314600", body)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1705314600\n"))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("Sign = %s, want %s", signature, want)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+	}{
+		{"other secret", "other", "1705314600", body},
+		{"other timestamp", "secret", "1705314601", body},
+		{"tampered body", "secret", "1705314600", []byte(`{"event":"anomaly.deleted"}`)},
+		{"timestamp moved into body", "secret", "", []byte("1705314600\n" + string(body))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Sign(tt.secret, tt.timestamp, tt.body) == signature {
+				t.Errorf("Sign(%q, %q, %q) matches the original signature", tt.secret, tt.timestamp, tt.body)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{9, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"api/internal/models"
+	"api/internal/notify"
+	"api/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Payload is the JSON body posted to webhooks.
+type Payload struct {
+	Event   string         `json:"event"`
+	Level   int            `json:"level,omitempty"`
+	Anomaly models.Anomaly `json:"anomaly"`
+}
+
+// Notifier queues deliveries in webhook_deliveries, rendered in each
+// webhook's format; the Deliverer sends them. It is both a notify.Notifier,
+// delivering every new anomaly to the webhooks whose filters match, and a
+// notify.Channel, so escalation policies can target a single webhook as
+// "webhook:<id>".
+type Notifier struct {
+	Webhooks *repository.WebhookRepository
+}
+
+func NewNotifier(db *sql.DB) *Notifier {
+	return &Notifier{
+		Webhooks: repository.NewWebhookRepository(db),
+	}
+}
+
+func (n *Notifier) NotifyAnomaly(anomaly models.Anomaly) error {
+	key := fmt.Sprintf("anomaly:%d", anomaly.ID)
+	for _, format := range Formats {
+		body, err := render(format, Payload{Event: notify.EventAnomaly, Anomaly: anomaly})
+		if err != nil {
+			return err
+		}
+		if _, err := n.Webhooks.EnqueueMatching(anomaly, format, notify.EventAnomaly, key, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that the recipient is the ID of an existing webhook.
+func (n *Notifier) Validate(recipient string) error {
+	_, err := n.webhook(recipient)
+	return err
+}
+
+func (n *Notifier) Send(recipient string, notification notify.Notification) error {
+	webhook, err := n.webhook(recipient)
+	if err != nil {
+		return err
+	}
+
+	body, err := render(webhook.Format, Payload{
+		Event:   notification.Event,
+		Level:   notification.Level,
+		Anomaly: notification.Anomaly,
+	})
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s:%d:%d", notification.Event, notification.Anomaly.ID, notification.Level)
+	err = n.Webhooks.EnqueueDelivery(webhook.ID, notification.Anomaly.ID, notification.Event, key, body)
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		// Deleted since it was loaded.
+		return fmt.Errorf("%w: %w", notify.ErrInvalidRecipient, err)
+	}
+	return err
+}
+
+func (n *Notifier) webhook(recipient string) (models.Webhook, error) {
+	webhookID, err := strconv.ParseInt(recipient, 10, 64)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("%w: webhook ID %q", notify.ErrInvalidRecipient, recipient)
+	}
+	webhook, found, err := n.Webhooks.GetWebhook(webhookID)
+	if err != nil {
+		return webhook, err
+	}
+	if !found {
+		return webhook, fmt.Errorf("%w: webhook %d: %w", notify.ErrInvalidRecipient, webhookID, repository.ErrWebhookNotFound)
+	}
+	return webhook, nil
+}
@@ -0,0 +1,49 @@
+package workflow
+
+// Anomaly statuses. New anomalies are open; false_positive and resolved
+// close an anomaly, and reopening it sets it back to open.
+const (
+	StatusOpen          = "open"
+	StatusAcknowledged  = "acknowledged"
+	StatusInvestigating = "investigating"
+	StatusFalsePositive = "false_positive"
+	StatusResolved      = "resolved"
+)
+
+var (
+	OpenStatuses   = []string{StatusOpen, StatusAcknowledged, StatusInvestigating}
+	ClosedStatuses = []string{StatusFalsePositive, StatusResolved}
+)
+
+// transitions lists the statuses each status may move to.
+var transitions = map[string][]string{
+	StatusOpen:          {StatusAcknowledged, StatusInvestigating, StatusFalsePositive, StatusResolved},
+	StatusAcknowledged:  {StatusInvestigating, StatusFalsePositive, StatusResolved},
+	StatusInvestigating: {StatusAcknowledged, StatusFalsePositive, StatusResolved},
+	StatusFalsePositive: {StatusOpen},
+	StatusResolved:      {StatusOpen},
+}
+
+func Valid(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+func Closed(status string) bool {
+	return status == StatusFalsePositive || status == StatusResolved
+}
+
+// CanTransition reports whether an anomaly in status from may be moved to
+// status to. Staying in the same status is allowed so the assignee or notes
+// can be changed on their own.
+func CanTransition(from, to string) bool {
+	if from == to {
+		return Valid(to)
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
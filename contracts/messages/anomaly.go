@@ -0,0 +1,108 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidAnomaly = errors.New("invalid anomaly")
+
+// Anomaly is published by the measurement processor when a reading fails
+// detection. ReadingID is the message ID of the reading that triggered it.
+// Threshold is the guideline value in µg/m³ the detector compares the
+// parameter against, or zero for parameters without one.
+type Anomaly struct {
+	ReadingID   string    `json:"readingId,omitempty"`
+	SensorID    string    `json:"sensorId,omitempty"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	Parameter   string    `json:"parameter"`
+	Value       float64   `json:"value"`
+	Timestamp   time.Time `json:"timestamp"`
+	Description string    `json:"description"`
+	Severity    string    `json:"severity,omitempty"`
+	Threshold   float64   `json:"threshold,omitempty"`
+}
+
+// AnomalyID is the stable ID of the anomaly raised for a reading, so the
+// alert keeps its ID when the reading is processed again.
+func AnomalyID(readingID string) string {
+	return DeriveMessageID(TypeAnomaly, readingID)
+}
+
+// ContentID derives an ID from the anomaly's fields, for legacy alerts that
+// were published without one.
+func (a Anomaly) ContentID() string {
+	return DeriveMessageID(
+		TypeAnomaly,
+		a.Parameter,
+		strconv.FormatFloat(a.Value, 'g', -1, 64),
+		strconv.FormatFloat(a.Latitude, 'f', 6, 64),
+		strconv.FormatFloat(a.Longitude, 'f', 6, 64),
+		a.Timestamp.UTC().Format(time.RFC3339Nano),
+		a.Description,
+	)
+}
+
+// Validate checks the fields every consumer relies on. A value of zero is
+// valid; negative and non-finite values are not.
+func (a Anomaly) Validate() error {
+	switch {
+	case strings.TrimSpace(a.Parameter) == "":
+		return fmt.Errorf("%w: missing parameter", ErrInvalidAnomaly)
+	case math.IsNaN(a.Value) || math.IsInf(a.Value, 0) || a.Value < 0:
+		return fmt.Errorf("%w: value must be a non-negative number", ErrInvalidAnomaly)
+	case math.IsNaN(a.Latitude) || a.Latitude < -90 || a.Latitude > 90:
+		return fmt.Errorf("%w: latitude must be between -90 and 90", ErrInvalidAnomaly)
+	case math.IsNaN(a.Longitude) || a.Longitude < -180 || a.Longitude > 180:
+		return fmt.Errorf("%w: longitude must be between -180 and 180", ErrInvalidAnomaly)
+	case a.Timestamp.IsZero():
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidAnomaly)
+	case strings.TrimSpace(a.Description) == "":
+		return fmt.Errorf("%w: missing description", ErrInvalidAnomaly)
+	}
+	return nil
+}
+
+func EncodeAnomaly(source, messageID string, anomaly Anomaly) ([]byte, error) {
+	return encode(TypeAnomaly, source, messageID, anomaly)
+}
+
+// DecodeAnomaly accepts an anomaly envelope or a legacy bare alert. Legacy
+// alerts carried the reading time as "timestamp" (processor) or "time"
+// (RFC 3339 string), so both are read.
+func DecodeAnomaly(body []byte) (Envelope, Anomaly, error) {
+	var anomaly Anomaly
+
+	envelope, err := decode(body, TypeAnomaly)
+	if err != nil {
+		return envelope, anomaly, err
+	}
+
+	if envelope.SchemaVersion == 0 {
+		var legacy struct {
+			Anomaly
+			Time string `json:"time"`
+		}
+		if err := json.Unmarshal(envelope.Data, &legacy); err != nil {
+			return envelope, anomaly, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		anomaly = legacy.Anomaly
+		if anomaly.Timestamp.IsZero() && legacy.Time != "" {
+			if anomaly.Timestamp, err = time.Parse(time.RFC3339, legacy.Time); err != nil {
+				return envelope, anomaly, fmt.Errorf("%w: invalid time %q", ErrMalformed, legacy.Time)
+			}
+		}
+		return envelope, anomaly, nil
+	}
+
+	if err := json.Unmarshal(envelope.Data, &anomaly); err != nil {
+		return envelope, anomaly, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return envelope, anomaly, nil
+}
@@ -0,0 +1,119 @@
+// Package messages defines the messages exchanged over RabbitMQ by the
+// ingest service, the measurement processor and the anomaly processor.
+//
+// Every message is a versioned envelope around a typed body. Decoders accept
+// the current schema version and every older one, including the bare
+// (version 0) JSON bodies published before envelopes were introduced, so
+// producers and consumers can be upgraded independently as long as
+// consumers are deployed first.
+package messages
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CurrentVersion is the schema version written by this package. Adding an
+// optional field does not change it; renaming, removing or changing the
+// meaning of a field does, together with a conversion in the decoder.
+const CurrentVersion = 1
+
+const (
+	TypeMeasurement = "measurement"
+	TypeAnomaly     = "anomaly"
+)
+
+// Source service names.
+const (
+	SourceIngest           = "air-quality-ingest"
+	SourceProcessor        = "air-quality-processor"
+	SourceAnomalyProcessor = "anomaly-processor"
+)
+
+const ContentType = "application/json"
+
+var (
+	ErrMalformed          = errors.New("malformed message")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrUnexpectedType     = errors.New("unexpected message type")
+)
+
+type Envelope struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Type          string          `json:"type"`
+	MessageID     string          `json:"messageId"`
+	ProducedAt    time.Time       `json:"producedAt"`
+	Source        string          `json:"source"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// encode wraps data in a current-version envelope. A missing message ID is
+// replaced with a random one.
+func encode(messageType, source, messageID string, data any) ([]byte, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if messageID == "" {
+		messageID = NewMessageID()
+	}
+
+	return json.Marshal(Envelope{
+		SchemaVersion: CurrentVersion,
+		Type:          messageType,
+		MessageID:     messageID,
+		ProducedAt:    time.Now().UTC(),
+		Source:        source,
+		Data:          body,
+	})
+}
+
+// decode unwraps an envelope of the expected type. Bodies without a
+// schemaVersion are legacy version 0 messages; the whole body is returned
+// as Data for the caller to convert.
+func decode(body []byte, messageType string) (Envelope, error) {
+	var probe struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if probe.SchemaVersion == nil {
+		return Envelope{Type: messageType, Data: body}, nil
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return envelope, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if envelope.SchemaVersion < 1 || envelope.SchemaVersion > CurrentVersion {
+		return envelope, fmt.Errorf("%w: %d", ErrUnsupportedVersion, envelope.SchemaVersion)
+	}
+	if envelope.Type != messageType {
+		return envelope, fmt.Errorf("%w: got %q, want %q", ErrUnexpectedType, envelope.Type, messageType)
+	}
+	if len(envelope.Data) == 0 {
+		return envelope, fmt.Errorf("%w: missing data", ErrMalformed)
+	}
+	return envelope, nil
+}
+
+// NewMessageID returns a random 128-bit hex encoded ID.
+func NewMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// DeriveMessageID returns a 128-bit hex encoded ID hashed from parts, for
+// messages that must keep the same ID when they are produced again.
+func DeriveMessageID(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,51 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Reading is a validated measurement published by the ingest service. Value
+// is in Unit, which the ingest service normalises to µg/m³.
+type Reading struct {
+	SensorID  string    `json:"sensorId,omitempty"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Parameter string    `json:"parameter"`
+	Value     float64   `json:"value"`
+	Unit      string    `json:"unit,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func EncodeReading(source, messageID string, reading Reading) ([]byte, error) {
+	return encode(TypeMeasurement, source, messageID, reading)
+}
+
+// DecodeReading accepts a measurement envelope or a legacy bare reading,
+// whose messageId is moved to the envelope.
+func DecodeReading(body []byte) (Envelope, Reading, error) {
+	var reading Reading
+
+	envelope, err := decode(body, TypeMeasurement)
+	if err != nil {
+		return envelope, reading, err
+	}
+
+	if envelope.SchemaVersion == 0 {
+		var legacy struct {
+			MessageID string `json:"messageId"`
+			Reading
+		}
+		if err := json.Unmarshal(envelope.Data, &legacy); err != nil {
+			return envelope, reading, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		envelope.MessageID = legacy.MessageID
+		return envelope, legacy.Reading, nil
+	}
+
+	if err := json.Unmarshal(envelope.Data, &reading); err != nil {
+		return envelope, reading, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return envelope, reading, nil
+}
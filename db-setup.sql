@@ -30,6 +30,264 @@ CREATE INDEX IF NOT EXISTS idx_measurements_geom
 SELECT create_hypertable('measurements', 'time', if_not_exists => TRUE);
 
 
+-- Sensor that produced the reading, for per-sensor time series
+ALTER TABLE measurements
+    ADD COLUMN IF NOT EXISTS sensor_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_measurements_sensor_time
+    ON measurements (sensor_id, time DESC);
+
+CREATE INDEX IF NOT EXISTS idx_measurements_parameter_time
+    ON measurements (parameter, time DESC);
+
 -- Spatial index for anomalies table
 CREATE INDEX IF NOT EXISTS idx_anomalies_geom
     ON anomalies USING GIST (location);
+
+-- Stable anomaly ID from the producer; redelivered alerts update the row
+-- with the same ID instead of inserting a duplicate
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS anomaly_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_anomalies_anomaly_id
+    ON anomalies (anomaly_id);
+
+-- Severity assigned by the measurement processor: critical, high or low
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS severity TEXT;
+
+-- Acknowledgement workflow: open, acknowledged, investigating,
+-- false_positive or resolved, with the assignee and operator notes
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS status            TEXT NOT NULL DEFAULT 'open',
+    ADD COLUMN IF NOT EXISTS assignee          TEXT,
+    ADD COLUMN IF NOT EXISTS notes             TEXT,
+    ADD COLUMN IF NOT EXISTS acknowledged_at   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS resolved_at       TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_status_time
+    ON anomalies (status, time DESC);
+
+-- How many escalation notifications were sent for an open anomaly and when
+-- the last one was sent; escalation_claimed_until leases the next step to
+-- the scheduler instance sending it
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS escalation_level         INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS escalated_at             TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS escalation_claimed_until TIMESTAMPTZ;
+
+-- When the anomaly processor stored the anomaly; time is the reading's
+-- time. Escalation delays count from detection. Existing rows take the
+-- reading time
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS detected_at TIMESTAMPTZ;
+
+UPDATE anomalies SET detected_at = time WHERE detected_at IS NULL;
+
+ALTER TABLE anomalies
+    ALTER COLUMN detected_at SET DEFAULT now(),
+    ALTER COLUMN detected_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_status_detected
+    ON anomalies (status, detected_at);
+
+-- Guideline value (µg/m³) the detector compared the reading against, as
+-- sent by the measurement processor; NULL for parameters without one
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS threshold DOUBLE PRECISION;
+
+-- Set once the anomaly has been handed to the notifiers (webhooks, ...)
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS notified_at TIMESTAMPTZ;
+
+-- Set once the anomaly has been broadcast to the WebSocket clients. The
+-- default only fills the rows that exist when the column is added, which
+-- were broadcast when they were stored
+ALTER TABLE anomalies
+    ADD COLUMN IF NOT EXISTS broadcast_at TIMESTAMPTZ DEFAULT now();
+
+ALTER TABLE anomalies
+    ALTER COLUMN broadcast_at DROP DEFAULT;
+
+-- Changes made to an anomaly after it was stored; anomaly_id refers to
+-- anomalies.id, not to the producer's anomalies.anomaly_id
+CREATE TABLE IF NOT EXISTS anomaly_history (
+    id          BIGSERIAL   PRIMARY KEY,
+    anomaly_id  INTEGER     NOT NULL REFERENCES anomalies (id) ON DELETE CASCADE,
+    time        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    event       TEXT        NOT NULL,
+    from_status TEXT,
+    to_status   TEXT,
+    actor       TEXT,
+    assignee    TEXT,
+    note        TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_anomaly_history_anomaly
+    ON anomaly_history (anomaly_id, time);
+
+-- Keyset pagination of the anomaly listing sorts by (time, id) or (value, id)
+CREATE INDEX IF NOT EXISTS idx_anomalies_time_id
+    ON anomalies (time DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_value_id
+    ON anomalies (value DESC, id DESC);
+
+-- Message IDs seen by the measurement processor, used to drop redelivered
+-- or retried readings within the idempotency window
+CREATE TABLE IF NOT EXISTS processed_messages (
+    message_id   TEXT        PRIMARY KEY,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_messages_processed_at
+    ON processed_messages (processed_at);
+
+-- Transactional outbox: alerts written by the measurement processor in the
+-- same transaction as the reading and published to RabbitMQ by the relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id          BIGSERIAL   PRIMARY KEY,
+    message_id  TEXT        NOT NULL,
+    routing_key TEXT        NOT NULL,
+    payload     JSONB       NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at     TIMESTAMPTZ,
+    attempts    INTEGER     NOT NULL DEFAULT 0,
+    last_error  TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending
+    ON outbox (id) WHERE sent_at IS NULL;
+
+-- Device registry: verification keys for sensors that sign their readings.
+-- hmac_secret is the shared HMAC-SHA256 secret, ed25519_public_key the
+-- base64 encoded 32 byte public key; either or both may be set
+CREATE TABLE IF NOT EXISTS devices (
+    sensor_id          TEXT        PRIMARY KEY,
+    hmac_secret        TEXT,
+    ed25519_public_key TEXT,
+    enabled            BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Where a fixed sensor stands, for MQTT sensors that publish bare values
+ALTER TABLE devices
+    ADD COLUMN IF NOT EXISTS latitude  DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+
+-- Rejected signatures and replays, kept for security review
+CREATE TABLE IF NOT EXISTS security_events (
+    id          BIGSERIAL   PRIMARY KEY,
+    time        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sensor_id   TEXT,
+    transport   TEXT        NOT NULL,
+    remote_addr TEXT,
+    reason      TEXT        NOT NULL,
+    nonce       TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_sensor_time
+    ON security_events (sensor_id, time DESC);
+
+-- Nonces of accepted signed readings, shared by every ingest instance so a
+-- replay is caught across replicas and restarts. Rows older than twice
+-- SIGNATURE_WINDOW are deleted by the ingest service
+CREATE TABLE IF NOT EXISTS signature_nonces (
+    sensor_id TEXT        NOT NULL,
+    nonce     TEXT        NOT NULL,
+    seen_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (sensor_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_signature_nonces_seen_at
+    ON signature_nonces (seen_at);
+
+-- Webhook subscribers. Empty parameters/severities and a NULL region match
+-- every anomaly; the region is a bounding box
+CREATE TABLE IF NOT EXISTS webhooks (
+    id                   SERIAL           PRIMARY KEY,
+    url                  TEXT             NOT NULL,
+    secret               TEXT             NOT NULL,
+    description          TEXT,
+    parameters           TEXT[]           NOT NULL DEFAULT '{}',
+    severities           TEXT[]           NOT NULL DEFAULT '{}',
+    min_lat              DOUBLE PRECISION,
+    min_lon              DOUBLE PRECISION,
+    max_lat              DOUBLE PRECISION,
+    max_lon              DOUBLE PRECISION,
+    enabled              BOOLEAN          NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER          NOT NULL DEFAULT 0,
+    disabled_at          TIMESTAMPTZ,
+    created_at           TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+-- Webhook delivery queue and log; delivery_key makes enqueueing idempotent
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               BIGSERIAL   PRIMARY KEY,
+    webhook_id       INTEGER     NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    anomaly_id       INTEGER     NOT NULL,
+    event            TEXT        NOT NULL,
+    delivery_key     TEXT        NOT NULL,
+    payload          JSONB       NOT NULL,
+    status           TEXT        NOT NULL DEFAULT 'pending',
+    attempts         INTEGER     NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error       TEXT,
+    next_attempt_at  TIMESTAMPTZ DEFAULT now(),
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at     TIMESTAMPTZ,
+    UNIQUE (webhook_id, delivery_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due
+    ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook
+    ON webhook_deliveries (webhook_id, created_at DESC);
+
+-- Body posted to the webhook: json (the signed payload), slack or teams
+-- (incoming webhook messages of the chat services)
+ALTER TABLE webhooks
+    ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'json';
+
+-- Alert subscriptions of citizens and staff: an area (a polygon, or a
+-- centre with a radius in kilometres), pollutants, a minimum severity,
+-- daily quiet hours in the subscriber's timezone and a notification
+-- channel target such as 'email:ayse@example.com' or 'webhook:3'
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id           SERIAL                    PRIMARY KEY,
+    user_id      TEXT                      NOT NULL,
+    name         TEXT,
+    area         geography(Geometry, 4326),
+    center       geography(Point, 4326),
+    radius_km    DOUBLE PRECISION,
+    parameters   TEXT[]                    NOT NULL DEFAULT '{}',
+    min_severity TEXT                      NOT NULL DEFAULT 'low',
+    quiet_start  TIME,
+    quiet_end    TIME,
+    timezone     TEXT                      NOT NULL DEFAULT 'UTC',
+    channel      TEXT                      NOT NULL,
+    enabled      BOOLEAN                   NOT NULL DEFAULT TRUE,
+    created_at   TIMESTAMPTZ               NOT NULL DEFAULT now(),
+    CHECK ((area IS NULL) <> (center IS NULL)),
+    CHECK (center IS NULL OR radius_km > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_area
+    ON subscriptions USING GIST (area);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_center
+    ON subscriptions USING GIST (center);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_user
+    ON subscriptions (user_id);
+
+-- Anomalies already routed to each subscription, so a redelivered anomaly
+-- is not sent twice
+CREATE TABLE IF NOT EXISTS subscription_notifications (
+    subscription_id INTEGER     NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+    anomaly_id      INTEGER     NOT NULL,
+    notified_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (subscription_id, anomaly_id)
+);